@@ -0,0 +1,286 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// APIKey is a credential of an APIKeyPool. ID identifies the key in responses, see
+// ChatCompletionResponse.APIKeyID, and must not be secret. OrgID overrides ClientConfig.OrgID if it is set.
+type APIKey struct {
+	ID    string
+	Token string
+	OrgID string
+}
+
+// APIKeySelection selects the key of the pool that sends a request.
+type APIKeySelection string
+
+const (
+	// APIKeySelectionRemainingCapacity picks the key with the largest share of its request and
+	// token limits remaining according to the rate limit headers of its last response, then
+	// the least recently rate limited one.
+	APIKeySelectionRemainingCapacity APIKeySelection = "remaining_capacity"
+	// APIKeySelectionLeastRecentlyLimited picks the key that was rate limited the longest ago,
+	// then the least recently used one.
+	APIKeySelectionLeastRecentlyLimited APIKeySelection = "least_recently_limited"
+)
+
+// APIKeyPoolConfig configures an APIKeyPool. Zero values are replaced by the defaults.
+type APIKeyPoolConfig struct {
+	// Selection defaults to APIKeySelectionRemainingCapacity.
+	Selection APIKeySelection
+	// RateLimitBench is the time a key is benched after a 429 response that does not report
+	// when the limit resets. Defaults to 20 seconds.
+	RateLimitBench time.Duration
+	// AuthBench is the time a key is benched after a 401 response. Defaults to 10 minutes.
+	AuthBench time.Duration
+	// QuotaBench is the time a key is benched after an insufficient_quota error. Defaults to
+	// one hour.
+	QuotaBench time.Duration
+}
+
+// APIKeyStatus is the state of a key of an APIKeyPool.
+type APIKeyStatus struct {
+	ID string
+	// Limits are the rate limit headers of the last response that reported them.
+	Limits RateLimitHeaders
+	// BenchedUntil is the time the key is used again, zero if it was never benched.
+	BenchedUntil time.Time
+}
+
+// APIKeyPool spreads the requests of a client across several API keys, see
+// ClientConfig.APIKeyPool. A key is picked for every attempt of a request, so a retry after a
+// rate limit goes out with another key. Keys are benched temporarily after 429 and 401
+// responses. If all keys are benched, the one that is benched the shortest is used anyway.
+type APIKeyPool struct {
+	config APIKeyPoolConfig
+
+	mu   sync.Mutex
+	keys []*pooledAPIKey
+}
+
+type pooledAPIKey struct {
+	APIKey
+
+	// limiter limits the requests sent with the key if the client has a RateLimiter.
+	limiter *RateLimiter
+
+	limits   RateLimitHeaders
+	limitsAt time.Time
+
+	lastUsed     time.Time
+	limitedAt    time.Time
+	benchedUntil time.Time
+}
+
+func NewAPIKeyPool(config APIKeyPoolConfig, keys ...APIKey) *APIKeyPool {
+	if config.Selection == "" {
+		config.Selection = APIKeySelectionRemainingCapacity
+	}
+	if config.RateLimitBench <= 0 {
+		config.RateLimitBench = 20 * time.Second
+	}
+	if config.AuthBench <= 0 {
+		config.AuthBench = 10 * time.Minute
+	}
+	if config.QuotaBench <= 0 {
+		config.QuotaBench = time.Hour
+	}
+
+	p := &APIKeyPool{config: config}
+	for _, key := range keys {
+		p.keys = append(p.keys, &pooledAPIKey{APIKey: key, limiter: NewRateLimiter(0, 0)})
+	}
+	return p
+}
+
+// Status returns the state of the keys in their order.
+func (p *APIKeyPool) Status() []APIKeyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]APIKeyStatus, 0, len(p.keys))
+	for _, key := range p.keys {
+		status = append(status, APIKeyStatus{
+			ID:           key.ID,
+			Limits:       key.limits,
+			BenchedUntil: key.benchedUntil,
+		})
+	}
+	return status
+}
+
+// acquire picks the key for the next request, or returns nil if the pool is empty.
+func (p *APIKeyPool) acquire() *pooledAPIKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+
+	var best *pooledAPIKey
+	for _, key := range p.keys {
+		if now.Before(key.benchedUntil) {
+			continue
+		}
+		if best == nil || p.prefer(key, best, now) {
+			best = key
+		}
+	}
+	if best == nil {
+		for _, key := range p.keys {
+			if best == nil || key.benchedUntil.Before(best.benchedUntil) {
+				best = key
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	best.lastUsed = now
+	// account for the request until its response reports the remaining requests
+	if best.limits.RemainingRequests > 0 {
+		best.limits.RemainingRequests--
+	}
+	return best
+}
+
+// prefer reports whether key a is a better pick than key b. It must be called with p.mu held.
+func (p *APIKeyPool) prefer(a, b *pooledAPIKey, now time.Time) bool {
+	if p.config.Selection == APIKeySelectionRemainingCapacity {
+		if ca, cb := a.capacity(now), b.capacity(now); ca != cb {
+			return ca > cb
+		}
+	}
+	if !a.limitedAt.Equal(b.limitedAt) {
+		return a.limitedAt.Before(b.limitedAt)
+	}
+	return a.lastUsed.Before(b.lastUsed)
+}
+
+// capacity returns the share of its request and token limits the key has left, 1 if unknown.
+func (k *pooledAPIKey) capacity(now time.Time) float64 {
+	remaining := func(limit, remaining int, reset time.Duration) float64 {
+		if limit <= 0 || (reset > 0 && now.Sub(k.limitsAt) >= reset) {
+			return 1
+		}
+		return float64(remaining) / float64(limit)
+	}
+	return min(
+		remaining(k.limits.LimitRequests, k.limits.RemainingRequests, k.limits.ResetRequests.Duration()),
+		remaining(k.limits.LimitTokens, k.limits.RemainingTokens, k.limits.ResetTokens.Duration()),
+	)
+}
+
+// update records the rate limit headers of a response sent with key and benches the key if
+// the response rejected it.
+func (p *APIKeyPool) update(key *pooledAPIKey, resp *http.Response) {
+	quota := resp.StatusCode == http.StatusTooManyRequests && isInsufficientQuota(resp)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if resp.Header.Get("x-ratelimit-limit-requests") != "" || resp.Header.Get("x-ratelimit-limit-tokens") != "" {
+		key.limits = newRateLimitHeaders(resp.Header)
+		key.limitsAt = now
+	}
+
+	var bench time.Duration
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		bench = p.config.AuthBench
+	case quota:
+		bench = p.config.QuotaBench
+	case resp.StatusCode == http.StatusTooManyRequests:
+		key.limitedAt = now
+		bench = retryAfter(resp)
+		if bench <= 0 {
+			bench = p.config.RateLimitBench
+		}
+	default:
+		return
+	}
+	if until := now.Add(bench); until.After(key.benchedUntil) {
+		key.benchedUntil = until
+	}
+}
+
+// isInsufficientQuota reports whether a failure response is an insufficient_quota error. The
+// body is restored so that it can be read again.
+func isInsufficientQuota(resp *http.Response) bool {
+	data, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	var errRes ErrorResponse
+	if json.Unmarshal(data, &errRes) != nil || errRes.Error == nil {
+		return false
+	}
+	return errRes.Error.Type == "insufficient_quota" || errRes.Error.Code == "insufficient_quota"
+}
+
+// doPooledHTTP sends a single attempt of a request with a key of the pool.
+func (c *Client) doPooledHTTP(pool *APIKeyPool, key *pooledAPIKey, req *http.Request, body []byte) (*http.Response, error) {
+	c.setAuthHeader(req.Header, key.Token)
+	orgID := key.OrgID
+	if orgID == "" {
+		orgID = c.config.OrgID
+	}
+	// the request may have been sent with another key before
+	if orgID != "" {
+		req.Header.Set("OpenAI-Organization", orgID)
+	} else {
+		req.Header.Del("OpenAI-Organization")
+	}
+
+	resp, err := c.doHedgeableHTTP(req.WithContext(context.WithValue(req.Context(), pooledAPIKeyContextKey{}, key)), body)
+	// a hedge request may have been sent to a target with its own key
+	if resp != nil && resp.Request != nil && sameAuth(resp.Request.Header, req.Header) {
+		pool.update(key, resp)
+		resp.Body = &pooledResponseBody{ReadCloser: resp.Body, keyID: key.ID}
+	}
+	return resp, err
+}
+
+type pooledAPIKeyContextKey struct{}
+
+// rateLimiter returns the limiter of a request: the one of the pooled key it is sent with, so
+// that every key is held to its own limits, or else the one of the client.
+func (c *Client) rateLimiter(req *http.Request) *RateLimiter {
+	if c.config.RateLimiter == nil {
+		return nil
+	}
+	key, _ := req.Context().Value(pooledAPIKeyContextKey{}).(*pooledAPIKey)
+	if key == nil {
+		return c.config.RateLimiter
+	}
+	header := http.Header{}
+	c.setAuthHeader(header, key.Token)
+	if !sameAuth(header, req.Header) {
+		// a hedge request sent to a target with its own key
+		return c.config.RateLimiter
+	}
+	return key.limiter
+}
+
+// pooledResponseBody is the body of a response to a request sent with a pooled key.
+type pooledResponseBody struct {
+	io.ReadCloser
+	keyID string
+}
+
+// apiKeyIDOf returns the ID of the pooled key the request of resp was sent with.
+func apiKeyIDOf(resp *http.Response) string {
+	if body, ok := resp.Body.(*pooledResponseBody); ok {
+		return body.keyID
+	}
+	return ""
+}
+
+func sameAuth(a, b http.Header) bool {
+	return a.Get("Authorization") == b.Get("Authorization") && a.Get(AzureAPIKeyHeader) == b.Get(AzureAPIKeyHeader)
+}
@@ -0,0 +1,78 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyPoolTakesPrecedenceOverCredentialProvider(t *testing.T) {
+	server := &chatCompletionServer{}
+	provider := &sequenceCredentialProvider{}
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.APIKeyPool = NewAPIKeyPool(APIKeyPoolConfig{}, APIKey{ID: "a", Token: "pooled"})
+		config.CredentialProvider = provider
+	})
+
+	response, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requests(); len(got) != 1 || got[0].header.Get("Authorization") != "Bearer pooled" {
+		t.Errorf("server did not receive a single request with the pooled key")
+	}
+	if calls := provider.calls.Load(); calls != 0 {
+		t.Errorf("credential provider was called %d times", calls)
+	}
+	if id := response.APIKeyID(); id != "a" {
+		t.Errorf("APIKeyID() = %q, want %q", id, "a")
+	}
+	for name := range response.Header() {
+		if name != "Content-Type" && name != "Content-Length" && name != "Date" {
+			t.Errorf("unexpected response header %s", name)
+		}
+	}
+}
+
+func TestAPIKeyPoolRateLimitsEveryKeySeparately(t *testing.T) {
+	// every response exhausts the request limit of its key for a minute
+	server := &chatCompletionServer{header: map[string]string{
+		"x-ratelimit-limit-requests":     "1",
+		"x-ratelimit-remaining-requests": "0",
+		"x-ratelimit-reset-requests":     "1m",
+	}}
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.RateLimiter = NewRateLimiter(0, 0)
+		config.APIKeyPool = NewAPIKeyPool(
+			APIKeyPoolConfig{Selection: APIKeySelectionLeastRecentlyLimited},
+			APIKey{ID: "a", Token: "key-a"},
+			APIKey{ID: "b", Token: "key-b"},
+		)
+	})
+
+	for _, id := range []string{"a", "b"} {
+		start := time.Now()
+		response, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.APIKeyID() != id {
+			t.Errorf("request was sent with key %q, want %q", response.APIKeyID(), id)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("request with key %s was delayed by %v by the limits of another key", id, elapsed)
+		}
+	}
+
+	// both keys are exhausted now
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.CreateChatCompletion(ctx, ChatCompletionRequest{Model: GPT4o}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := server.requests(); len(got) != 2 {
+		t.Errorf("server received %d requests, want 2", len(got))
+	}
+}
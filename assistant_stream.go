@@ -0,0 +1,122 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"strings"
+)
+
+// Assistant stream event names, see https://platform.openai.com/docs/api-reference/assistants-streaming/events.
+const (
+	AssistantStreamEventThreadCreated = "thread.created"
+
+	AssistantStreamEventRunCreated        = "thread.run.created"
+	AssistantStreamEventRunQueued         = "thread.run.queued"
+	AssistantStreamEventRunInProgress     = "thread.run.in_progress"
+	AssistantStreamEventRunRequiresAction = "thread.run.requires_action"
+	AssistantStreamEventRunCompleted      = "thread.run.completed"
+	AssistantStreamEventRunIncomplete     = "thread.run.incomplete"
+	AssistantStreamEventRunFailed         = "thread.run.failed"
+	AssistantStreamEventRunCancelling     = "thread.run.cancelling"
+	AssistantStreamEventRunCancelled      = "thread.run.cancelled"
+	AssistantStreamEventRunExpired        = "thread.run.expired"
+
+	AssistantStreamEventRunStepCreated    = "thread.run.step.created"
+	AssistantStreamEventRunStepInProgress = "thread.run.step.in_progress"
+	AssistantStreamEventRunStepDelta      = "thread.run.step.delta"
+	AssistantStreamEventRunStepCompleted  = "thread.run.step.completed"
+	AssistantStreamEventRunStepFailed     = "thread.run.step.failed"
+	AssistantStreamEventRunStepCancelled  = "thread.run.step.cancelled"
+	AssistantStreamEventRunStepExpired    = "thread.run.step.expired"
+
+	AssistantStreamEventMessageCreated    = "thread.message.created"
+	AssistantStreamEventMessageInProgress = "thread.message.in_progress"
+	AssistantStreamEventMessageDelta      = "thread.message.delta"
+	AssistantStreamEventMessageCompleted  = "thread.message.completed"
+	AssistantStreamEventMessageIncomplete = "thread.message.incomplete"
+)
+
+// AssistantStreamEvent is an event of an Assistants run stream. Depending on Event, exactly
+// one of the typed fields is set; the data of events without a typed field is left in Data.
+type AssistantStreamEvent struct {
+	Event string
+	Data  json.RawMessage
+
+	Run          *Run
+	RunStep      *RunStep
+	RunStepDelta *RunStepDelta
+	Message      *Message
+	MessageDelta *MessageDelta
+}
+
+type RunStepDelta struct {
+	ID     string `json:"id"`
+	Object string `json:"object"`
+	Delta  struct {
+		StepDetails RunStepDetails `json:"step_details"`
+	} `json:"delta"`
+}
+
+type MessageDelta struct {
+	ID     string `json:"id"`
+	Object string `json:"object"`
+	Delta  struct {
+		Role    string                `json:"role,omitempty"`
+		Content []MessageDeltaContent `json:"content,omitempty"`
+	} `json:"delta"`
+}
+
+type MessageDeltaContent struct {
+	Index     int          `json:"index"`
+	Type      string       `json:"type"`
+	Text      *MessageText `json:"text,omitempty"`
+	ImageFile *ImageFile   `json:"image_file,omitempty"`
+}
+
+func (e *AssistantStreamEvent) unmarshalSSE(event string, data []byte) error {
+	e.Event = event
+	e.Data = append(json.RawMessage(nil), data...)
+
+	var v any
+	switch {
+	case event == AssistantStreamEventRunStepDelta:
+		e.RunStepDelta = &RunStepDelta{}
+		v = e.RunStepDelta
+	case strings.HasPrefix(event, "thread.run.step."):
+		e.RunStep = &RunStep{}
+		v = e.RunStep
+	case strings.HasPrefix(event, "thread.run."):
+		e.Run = &Run{}
+		v = e.Run
+	case event == AssistantStreamEventMessageDelta:
+		e.MessageDelta = &MessageDelta{}
+		v = e.MessageDelta
+	case strings.HasPrefix(event, "thread.message."):
+		e.Message = &Message{}
+		v = e.Message
+	default:
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// AssistantStream is a stream of the events of an Assistants run. Recv returns io.EOF
+// after the done event.
+type AssistantStream struct {
+	*streamReader[AssistantStreamEvent]
+}
+
+// All returns an iterator over the events of the stream, ending at io.EOF. An error
+// is yielded once as the last element. The stream is closed when the loop ends,
+// including when it is stopped early.
+func (stream *AssistantStream) All() iter.Seq2[AssistantStreamEvent, error] {
+	return streamAll[AssistantStreamEvent](stream)
+}
+
+// Chan returns a channel delivering the events of the stream. An error is delivered
+// once as the last result. The channel and the stream are closed when the stream
+// ends, fails or ctx is done.
+func (stream *AssistantStream) Chan(ctx context.Context) <-chan StreamResult[AssistantStreamEvent] {
+	return streamChan[AssistantStreamEvent](ctx, stream)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
 )
 
@@ -82,6 +83,7 @@ type ChatMessagePart struct {
 type ChatCompletionMessage struct {
 	Role         string `json:"role"`
 	Content      string `json:"content"`
+	Refusal      string `json:"refusal,omitempty"`
 	MultiContent []ChatMessagePart
 
 	// This property isn't in the official documentation, but it's in
@@ -107,6 +109,7 @@ func (m ChatCompletionMessage) MarshalJSON() ([]byte, error) {
 		msg := struct {
 			Role         string            `json:"role"`
 			Content      string            `json:"-"`
+			Refusal      string            `json:"refusal,omitempty"`
 			MultiContent []ChatMessagePart `json:"content,omitempty"`
 			Name         string            `json:"name,omitempty"`
 			FunctionCall *FunctionCall     `json:"function_call,omitempty"`
@@ -118,6 +121,7 @@ func (m ChatCompletionMessage) MarshalJSON() ([]byte, error) {
 	msg := struct {
 		Role         string            `json:"role"`
 		Content      string            `json:"content"`
+		Refusal      string            `json:"refusal,omitempty"`
 		MultiContent []ChatMessagePart `json:"-"`
 		Name         string            `json:"name,omitempty"`
 		FunctionCall *FunctionCall     `json:"function_call,omitempty"`
@@ -131,6 +135,7 @@ func (m *ChatCompletionMessage) UnmarshalJSON(bs []byte) error {
 	msg := struct {
 		Role         string `json:"role"`
 		Content      string `json:"content"`
+		Refusal      string `json:"refusal,omitempty"`
 		MultiContent []ChatMessagePart
 		Name         string        `json:"name,omitempty"`
 		FunctionCall *FunctionCall `json:"function_call,omitempty"`
@@ -144,6 +149,7 @@ func (m *ChatCompletionMessage) UnmarshalJSON(bs []byte) error {
 	multiMsg := struct {
 		Role         string `json:"role"`
 		Content      string
+		Refusal      string            `json:"refusal,omitempty"`
 		MultiContent []ChatMessagePart `json:"content"`
 		Name         string            `json:"name,omitempty"`
 		FunctionCall *FunctionCall     `json:"function_call,omitempty"`
@@ -273,6 +279,11 @@ type LogProb struct {
 	TopLogProbs []TopLogProbs `json:"top_logprobs"`
 }
 
+// Probability returns the linear probability of the token.
+func (l LogProb) Probability() float64 {
+	return math.Exp(l.LogProb)
+}
+
 // LogProbs is the top-level structure containing the log probability information.
 type LogProbs struct {
 	// Content is a list of message content tokens with log probability information.
@@ -321,6 +332,9 @@ type ChatCompletionResponse struct {
 	Usage             Usage                  `json:"usage"`
 	SystemFingerprint string                 `json:"system_fingerprint"`
 
+	// Endpoint is the name of the endpoint of a FailoverClient that served the response.
+	Endpoint string `json:"-"`
+
 	httpHeader
 }
 
@@ -330,6 +344,22 @@ func (c *Client) CreateChatCompletion(
 	request ChatCompletionRequest,
 	headers map[string]string,
 	retryOpts ...RetryOptions,
+) (response ChatCompletionResponse, err error) {
+	return invoke(ctx, c, &Call{
+		Operation:    "CreateChatCompletion",
+		Method:       http.MethodPost,
+		Path:         chatCompletionsSuffix,
+		Model:        request.Model,
+		Request:      request,
+		Headers:      headers,
+		RetryOptions: retryOpts,
+	}, c.createChatCompletion)
+}
+
+func (c *Client) createChatCompletion(
+	ctx context.Context,
+	request ChatCompletionRequest,
+	call *Call,
 ) (response ChatCompletionResponse, err error) {
 	if request.Stream {
 		err = ErrChatCompletionStreamNotSupported
@@ -338,15 +368,15 @@ func (c *Client) CreateChatCompletion(
 
 	urlSuffix := chatCompletionsSuffix
 
-	req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix, request.Model), withBody(request))
+	req, err := c.newRequest(withHedging(ctx), http.MethodPost, c.fullURL(urlSuffix, request.Model), withBody(request))
 	if err != nil {
 		return
 	}
 
-	for k, v := range headers {
+	for k, v := range call.Headers {
 		req.Header.Add(k, v)
 	}
 
-	err = c.sendRequest(req, &response, retryOpts...)
+	err = c.sendRequest(req, &response, call.RetryOptions...)
 	return
 }
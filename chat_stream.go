@@ -2,11 +2,17 @@ package openai
 
 import (
 	"context"
+	"errors"
+	"io"
+	"iter"
+	"math/rand"
 	"net/http"
+	"sync"
 )
 
 type ChatCompletionStreamChoiceDelta struct {
 	Content      string        `json:"content,omitempty"`
+	Refusal      string        `json:"refusal,omitempty"`
 	Role         string        `json:"role,omitempty"`
 	FunctionCall *FunctionCall `json:"function_call,omitempty"`
 	ToolCalls    []ToolCall    `json:"tool_calls,omitempty"`
@@ -17,6 +23,9 @@ type ChatCompletionStreamChoice struct {
 	Delta                ChatCompletionStreamChoiceDelta `json:"delta"`
 	FinishReason         FinishReason                    `json:"finish_reason"`
 	ContentFilterResults ContentFilterResults            `json:"content_filter_results,omitempty"`
+	// LogProbs holds the log probabilities of the content tokens in Delta if
+	// ChatCompletionRequest.LogProbs is set.
+	LogProbs *LogProbs `json:"logprobs,omitempty"`
 }
 
 type ChatCompletionStreamResponse struct {
@@ -27,12 +36,39 @@ type ChatCompletionStreamResponse struct {
 	Choices           []ChatCompletionStreamChoice `json:"choices"`
 	PromptAnnotations []PromptAnnotation           `json:"prompt_annotations,omitempty"`
 	Usage             Usage                        `json:"usage,omitempty"`
+	SystemFingerprint string                       `json:"system_fingerprint,omitempty"`
+
+	// Resume is set on chunks received after the stream was resumed, see RetryOptions.StreamResumes.
+	Resume *ChatCompletionStreamResume `json:"-"`
 }
 
 // ChatCompletionStream
 // Note: Perhaps it is more elegant to abstract Stream using generics.
 type ChatCompletionStream struct {
 	*streamReader[ChatCompletionStreamResponse]
+
+	request ChatCompletionRequest
+	options RetryOptions
+	open    func(request ChatCompletionRequest) (*streamReader[ChatCompletionStreamResponse], error)
+
+	// delivered accumulates the chunks returned to the caller when resuming is enabled.
+	delivered *ChatCompletionStreamAccumulator
+	resumes   int
+	replay    *streamReplay
+	// err is the error resuming the stream failed with, which ends it for good.
+	err error
+	// raw is the data the last chunk was decoded from, nil if the chunk was changed since.
+	raw []byte
+
+	stats    *streamStatsCollector
+	onToken  []func(ChatCompletionStreamToken)
+	recorder *streamRecorder
+	received bool
+	endpoint string
+
+	// mu guards swapping streamReader on resume against a concurrent Close.
+	mu     sync.Mutex
+	closed bool
 }
 
 // CreateChatCompletionStream — API call to create a chat completion w/ streaming
@@ -45,23 +81,159 @@ func (c *Client) CreateChatCompletionStream(
 	headers map[string]string,
 	retryOpts ...RetryOptions,
 ) (stream *ChatCompletionStream, err error) {
-	urlSuffix := chatCompletionsSuffix
+	return invoke(ctx, c, &Call{
+		Operation:    "CreateChatCompletionStream",
+		Method:       http.MethodPost,
+		Path:         chatCompletionsSuffix,
+		Model:        request.Model,
+		Request:      request,
+		Headers:      headers,
+		RetryOptions: retryOpts,
+	}, c.createChatCompletionStream)
+}
+
+func (c *Client) createChatCompletionStream(
+	ctx context.Context,
+	request ChatCompletionRequest,
+	call *Call,
+) (stream *ChatCompletionStream, err error) {
+	headers, retryOpts := call.Headers, call.RetryOptions
+	options := NewDefaultRetryOptions()
+	options.complete(retryOpts...)
+
 	request.Stream = true
-	req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix, request.Model), withBody(request))
-	if err != nil {
-		return nil, err
+	if options.StreamResumes > 0 && request.Seed == nil {
+		// a fixed seed makes a restarted generation as close as possible to the broken one
+		seed := rand.Int()
+		request.Seed = &seed
 	}
 
-	for k, v := range headers {
-		req.Header.Add(k, v)
+	open := func(request ChatCompletionRequest) (*streamReader[ChatCompletionStreamResponse], error) {
+		urlSuffix := chatCompletionsSuffix
+		req, err := c.newRequest(withHedging(ctx), http.MethodPost, c.fullURL(urlSuffix, request.Model), withBody(request))
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+
+		return sendRequestStream[ChatCompletionStreamResponse](c, req, retryOpts...)
 	}
 
-	resp, err := sendRequestStream[ChatCompletionStreamResponse](c, req, retryOpts...)
+	resp, err := open(request)
 	if err != nil {
 		return
 	}
 	stream = &ChatCompletionStream{
 		streamReader: resp,
+		request:      request,
+		options:      options,
+		open:         open,
+		stats:        newStreamStatsCollector(resp.sentAt, resp.headersAt, c.config.StreamStatsHook),
+	}
+	if options.StreamResumes > 0 {
+		stream.delivered = NewChatCompletionStreamAccumulator(nil)
 	}
 	return
 }
+
+// Recv returns the next chunk of the stream, or io.EOF once the stream is finished.
+// If RetryOptions.StreamResumes is set, a stream that breaks mid-way is re-established
+// transparently and the chunks received afterwards carry a ChatCompletionStreamResume.
+func (stream *ChatCompletionStream) Recv() (ChatCompletionStreamResponse, error) {
+	if !stream.received {
+		stream.received = true
+		stream.tee.stopBuffering()
+	}
+
+	chunk, err := stream.recv()
+	if errors.Is(err, io.EOF) {
+		stream.stats.finish(nil)
+		return chunk, err
+	}
+	if err != nil {
+		stream.stats.finish(err)
+		return chunk, err
+	}
+
+	stream.stats.chunk(chunk, stream.receivedAt)
+	stream.emitTokens(chunk)
+	return chunk, nil
+}
+
+func (stream *ChatCompletionStream) recv() (ChatCompletionStreamResponse, error) {
+	stream.raw = nil
+	if stream.err != nil {
+		return ChatCompletionStreamResponse{}, stream.err
+	}
+	for {
+		reader := stream.reader()
+		chunk, err := reader.Recv()
+		if err != nil {
+			if !stream.canResume(err) {
+				return chunk, err
+			}
+			if resumeErr := stream.resume(err); resumeErr != nil {
+				// the broken reader is closed already, so it must not be read again
+				stream.err = resumeErr
+				return ChatCompletionStreamResponse{}, resumeErr
+			}
+			continue
+		}
+
+		if stream.replay != nil {
+			var keep bool
+			chunk, keep, err = stream.replay.filter(chunk)
+			if err != nil {
+				stream.err = err
+				return ChatCompletionStreamResponse{}, err
+			}
+			if !keep {
+				continue
+			}
+		} else {
+			stream.raw = reader.data
+		}
+		if stream.delivered != nil {
+			stream.delivered.Add(chunk)
+		}
+		return chunk, nil
+	}
+}
+
+// Close closes the stream. It may be called concurrently with Recv to abort it.
+func (stream *ChatCompletionStream) Close() error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.closed = true
+	stream.stats.finish(nil)
+	return stream.streamReader.Close()
+}
+
+// Stats returns the timing data collected so far. They are final once the stream has
+// ended or was closed.
+func (stream *ChatCompletionStream) Stats() ChatCompletionStreamStats {
+	return stream.stats.get()
+}
+
+// Endpoint returns the name of the endpoint of a FailoverClient that serves the stream.
+func (stream *ChatCompletionStream) Endpoint() string {
+	return stream.endpoint
+}
+
+// All returns an iterator over the chunks of the stream, ending at io.EOF. An error
+// is yielded once as the last element. The stream is closed when the loop ends,
+// including when it is stopped early.
+func (stream *ChatCompletionStream) All() iter.Seq2[ChatCompletionStreamResponse, error] {
+	return streamAll[ChatCompletionStreamResponse](stream)
+}
+
+// Chan returns a channel delivering the chunks of the stream. An error is delivered
+// once as the last result. The channel and the stream are closed when the stream
+// ends, fails or ctx is done.
+func (stream *ChatCompletionStream) Chan(ctx context.Context) <-chan StreamResult[ChatCompletionStreamResponse] {
+	return streamChan[ChatCompletionStreamResponse](ctx, stream)
+}
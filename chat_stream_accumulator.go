@@ -0,0 +1,226 @@
+package openai
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const chatCompletionObject = "chat.completion"
+
+// ChatCompletionStreamAccumulator collects the chunks of a ChatCompletionStream and
+// rebuilds the ChatCompletionResponse that CreateChatCompletion would have returned
+// for the same request.
+type ChatCompletionStreamAccumulator struct {
+	stream *ChatCompletionStream
+
+	mu       sync.Mutex
+	response ChatCompletionResponse
+	choices  []*accumulatedChoice
+}
+
+type accumulatedChoice struct {
+	index        int
+	role         string
+	content      strings.Builder
+	refusal      strings.Builder
+	functionCall *FunctionCall
+	toolCalls    []ToolCall
+	toolIndex    map[int]int
+	finishReason FinishReason
+	logProbs     []LogProb
+}
+
+// NewChatCompletionStreamAccumulator creates an accumulator reading from stream.
+// stream may be nil if chunks are fed manually through Add.
+func NewChatCompletionStreamAccumulator(stream *ChatCompletionStream) *ChatCompletionStreamAccumulator {
+	return &ChatCompletionStreamAccumulator{
+		stream: stream,
+	}
+}
+
+// Recv receives the next chunk from the underlying stream and adds it to the
+// accumulated response. It returns io.EOF once the stream is finished.
+func (a *ChatCompletionStreamAccumulator) Recv() (ChatCompletionStreamResponse, error) {
+	chunk, err := a.stream.Recv()
+	if err != nil {
+		return chunk, err
+	}
+	a.Add(chunk)
+	return chunk, nil
+}
+
+// Response drains the remaining chunks of the underlying stream and returns the
+// complete response. The stream is not closed.
+func (a *ChatCompletionStreamAccumulator) Response() (ChatCompletionResponse, error) {
+	for {
+		_, err := a.Recv()
+		if errors.Is(err, io.EOF) {
+			return a.Snapshot(), nil
+		}
+		if err != nil {
+			return a.Snapshot(), err
+		}
+	}
+}
+
+// Add merges a chunk into the accumulated response.
+func (a *ChatCompletionStreamAccumulator) Add(chunk ChatCompletionStreamResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if chunk.ID != "" {
+		a.response.ID = chunk.ID
+	}
+	if chunk.Created != 0 {
+		a.response.Created = chunk.Created
+	}
+	if chunk.Model != "" {
+		a.response.Model = chunk.Model
+	}
+	if chunk.SystemFingerprint != "" {
+		a.response.SystemFingerprint = chunk.SystemFingerprint
+	}
+	// With StreamOptions.IncludeUsage the usage is sent in a final chunk without choices.
+	if chunk.Usage != (Usage{}) {
+		a.response.Usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		a.choice(choice.Index).add(choice)
+	}
+}
+
+// Snapshot returns a copy of the response accumulated so far. It is safe to call
+// while another goroutine is receiving from the stream.
+func (a *ChatCompletionStreamAccumulator) Snapshot() ChatCompletionResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	response := a.response
+	response.Object = chatCompletionObject
+	response.Choices = make([]ChatCompletionChoice, 0, len(a.choices))
+	for _, choice := range a.choices {
+		response.Choices = append(response.Choices, choice.snapshot())
+	}
+	if a.stream != nil {
+		// the reader is replaced when the stream is resumed
+		if reader := a.stream.reader(); reader != nil {
+			response.httpHeader = reader.httpHeader
+		}
+	}
+	return response
+}
+
+func (a *ChatCompletionStreamAccumulator) choice(index int) *accumulatedChoice {
+	i, found := slices.BinarySearchFunc(a.choices, index, func(c *accumulatedChoice, index int) int {
+		return c.index - index
+	})
+	if !found {
+		a.choices = slices.Insert(a.choices, i, &accumulatedChoice{
+			index:     index,
+			toolIndex: map[int]int{},
+		})
+	}
+	return a.choices[i]
+}
+
+func (c *accumulatedChoice) add(choice ChatCompletionStreamChoice) {
+	delta := choice.Delta
+	if delta.Role != "" {
+		c.role = delta.Role
+	}
+	c.content.WriteString(delta.Content)
+	c.refusal.WriteString(delta.Refusal)
+
+	if delta.FunctionCall != nil {
+		if c.functionCall == nil {
+			c.functionCall = &FunctionCall{}
+		}
+		c.functionCall.Name += delta.FunctionCall.Name
+		c.functionCall.Arguments += delta.FunctionCall.Arguments
+	}
+
+	for _, toolCall := range delta.ToolCalls {
+		c.addToolCall(toolCall)
+	}
+
+	if choice.LogProbs != nil {
+		c.logProbs = append(c.logProbs, choice.LogProbs.Content...)
+	}
+
+	if choice.FinishReason != "" && choice.FinishReason != FinishReasonNull {
+		c.finishReason = choice.FinishReason
+	}
+}
+
+// addToolCall merges a tool call fragment and returns the position of its tool call.
+func (c *accumulatedChoice) addToolCall(fragment ToolCall) int {
+	var index int
+	switch {
+	case fragment.Index != nil:
+		index = *fragment.Index
+	case len(c.toolCalls) == 0:
+		index = 0
+	default:
+		// Some providers omit the index, in which case a new ID starts a new tool call.
+		index = len(c.toolCalls) - 1
+		if fragment.ID != "" && fragment.ID != c.toolCalls[index].ID {
+			index++
+		}
+	}
+
+	pos, ok := c.toolIndex[index]
+	if !ok {
+		pos = len(c.toolCalls)
+		c.toolIndex[index] = pos
+		c.toolCalls = append(c.toolCalls, ToolCall{})
+	}
+
+	toolCall := &c.toolCalls[pos]
+	if fragment.ID != "" {
+		toolCall.ID = fragment.ID
+	}
+	if fragment.Type != "" {
+		toolCall.Type = fragment.Type
+	}
+	toolCall.Function.Name += fragment.Function.Name
+	toolCall.Function.Arguments += fragment.Function.Arguments
+	return pos
+}
+
+func (c *accumulatedChoice) snapshot() ChatCompletionChoice {
+	role := c.role
+	if role == "" {
+		role = ChatMessageRoleAssistant
+	}
+
+	choice := ChatCompletionChoice{
+		Index: c.index,
+		Message: ChatCompletionMessage{
+			Role:    role,
+			Content: c.content.String(),
+			Refusal: c.refusal.String(),
+		},
+		FinishReason: c.finishReason,
+	}
+	if c.logProbs != nil {
+		choice.LogProbs = &LogProbs{Content: slices.Clone(c.logProbs)}
+	}
+	if c.functionCall != nil {
+		functionCall := *c.functionCall
+		choice.Message.FunctionCall = &functionCall
+	}
+	if len(c.toolCalls) > 0 {
+		choice.Message.ToolCalls = make([]ToolCall, len(c.toolCalls))
+		for i, toolCall := range c.toolCalls {
+			if toolCall.Type == "" {
+				toolCall.Type = ToolTypeFunction
+			}
+			choice.Message.ToolCalls[i] = toolCall
+		}
+	}
+	return choice
+}
@@ -0,0 +1,218 @@
+package openai
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// SlowSubscriberPolicy selects what a ChatCompletionStreamBroadcaster does with a subscriber
+// whose buffer is full when the next chunk arrives.
+type SlowSubscriberPolicy string
+
+const (
+	// SlowSubscriberBlock pauses reading from the upstream, and so delivery to all other
+	// subscribers, until the subscriber catches up or is closed.
+	SlowSubscriberBlock SlowSubscriberPolicy = "block"
+	// SlowSubscriberDrop skips the chunk for the subscriber. The number of skipped chunks is
+	// reported by ChatCompletionStreamSubscriber.Dropped.
+	SlowSubscriberDrop SlowSubscriberPolicy = "drop"
+	// SlowSubscriberDisconnect detaches the subscriber. Once it has received the chunks in its
+	// buffer, Recv returns ErrSlowSubscriber.
+	SlowSubscriberDisconnect SlowSubscriberPolicy = "disconnect"
+)
+
+var ErrSlowSubscriber = errors.New("subscriber was disconnected because it could not keep up with the stream")
+
+// ChatCompletionStreamBroadcaster delivers every chunk of a ChatCompletionStream to any
+// number of subscribers, see ChatCompletionStream.Broadcast.
+type ChatCompletionStreamBroadcaster struct {
+	stream *ChatCompletionStream
+
+	mu          sync.Mutex
+	subscribers []*ChatCompletionStreamSubscriber
+	started     bool
+	finished    bool
+	err         error
+}
+
+// ChatCompletionStreamSubscriber receives the chunks of a broadcast stream.
+type ChatCompletionStreamSubscriber struct {
+	broadcaster *ChatCompletionStreamBroadcaster
+	policy      SlowSubscriberPolicy
+
+	// chunks is closed by the broadcaster after err is set.
+	chunks chan ChatCompletionStreamResponse
+	err    error
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Broadcast creates a broadcaster for the stream. Subscribers are attached with Subscribe
+// and the broadcast begins with Start. The stream must not be consumed directly after
+// calling Broadcast.
+func (stream *ChatCompletionStream) Broadcast() *ChatCompletionStreamBroadcaster {
+	return &ChatCompletionStreamBroadcaster{
+		stream: stream,
+	}
+}
+
+// Subscribe attaches a subscriber that buffers up to buffer chunks and is handled according
+// to policy when its buffer is full. An empty policy defaults to SlowSubscriberBlock.
+// A subscriber attached after Start receives the chunks from that point on, and one attached
+// after the stream ended only receives the error the stream ended with.
+func (b *ChatCompletionStreamBroadcaster) Subscribe(buffer int, policy SlowSubscriberPolicy) *ChatCompletionStreamSubscriber {
+	if policy == "" {
+		policy = SlowSubscriberBlock
+	}
+	s := &ChatCompletionStreamSubscriber{
+		broadcaster: b,
+		policy:      policy,
+		chunks:      make(chan ChatCompletionStreamResponse, buffer),
+		closed:      make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.finished {
+		s.err = b.err
+		close(s.chunks)
+		return s
+	}
+	b.subscribers = append(b.subscribers, s)
+	return s
+}
+
+// Start begins reading from the upstream. It returns immediately; the upstream is read in
+// the background until it ends or the last subscriber detaches, at which point it is closed.
+func (b *ChatCompletionStreamBroadcaster) Start() {
+	b.mu.Lock()
+	if b.started {
+		b.mu.Unlock()
+		return
+	}
+	b.started = true
+	empty := len(b.subscribers) == 0
+	b.mu.Unlock()
+
+	if empty {
+		b.finish(b.stream.Close())
+		return
+	}
+	go b.run()
+}
+
+func (b *ChatCompletionStreamBroadcaster) run() {
+	for {
+		chunk, err := b.stream.Recv()
+		if err != nil {
+			_ = b.stream.Close()
+			b.finish(err)
+			return
+		}
+
+		b.mu.Lock()
+		subscribers := slices.Clone(b.subscribers)
+		b.mu.Unlock()
+
+		for _, s := range subscribers {
+			b.deliver(s, chunk)
+		}
+	}
+}
+
+func (b *ChatCompletionStreamBroadcaster) deliver(s *ChatCompletionStreamSubscriber, chunk ChatCompletionStreamResponse) {
+	if s.policy == SlowSubscriberBlock {
+		select {
+		case s.chunks <- chunk:
+		case <-s.closed:
+		}
+		return
+	}
+
+	select {
+	case s.chunks <- chunk:
+	case <-s.closed:
+	default:
+		if s.policy == SlowSubscriberDrop {
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+			return
+		}
+
+		if b.detach(s) {
+			s.err = ErrSlowSubscriber
+			close(s.chunks)
+		}
+	}
+}
+
+// finish ends the broadcast for all remaining subscribers.
+func (b *ChatCompletionStreamBroadcaster) finish(err error) {
+	b.mu.Lock()
+	b.finished = true
+	b.err = err
+	subscribers := b.subscribers
+	b.subscribers = nil
+	b.mu.Unlock()
+
+	for _, s := range subscribers {
+		s.err = err
+		close(s.chunks)
+	}
+}
+
+// detach removes a subscriber and closes the upstream if it was the last one. It reports
+// false if the subscriber was already detached.
+func (b *ChatCompletionStreamBroadcaster) detach(s *ChatCompletionStreamSubscriber) bool {
+	b.mu.Lock()
+	i := slices.Index(b.subscribers, s)
+	if i < 0 {
+		b.mu.Unlock()
+		return false
+	}
+	b.subscribers = slices.Delete(b.subscribers, i, i+1)
+	last := b.started && len(b.subscribers) == 0
+	b.mu.Unlock()
+
+	if last {
+		_ = b.stream.Close()
+	}
+	return true
+}
+
+// Recv returns the next chunk, or io.EOF once the stream is finished or the subscriber is closed.
+func (s *ChatCompletionStreamSubscriber) Recv() (ChatCompletionStreamResponse, error) {
+	select {
+	case <-s.closed:
+		return ChatCompletionStreamResponse{}, io.EOF
+	case chunk, ok := <-s.chunks:
+		if !ok {
+			return ChatCompletionStreamResponse{}, s.err
+		}
+		return chunk, nil
+	}
+}
+
+// Dropped returns the number of chunks skipped under SlowSubscriberDrop.
+func (s *ChatCompletionStreamSubscriber) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close detaches the subscriber. Its remaining chunks are discarded. The upstream is closed
+// when the last subscriber is closed.
+func (s *ChatCompletionStreamSubscriber) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.broadcaster.detach(s)
+	})
+	return nil
+}
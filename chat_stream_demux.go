@@ -0,0 +1,173 @@
+package openai
+
+import (
+	"io"
+	"sync"
+)
+
+// ChatCompletionChoiceStream delivers the chunks of a single choice of a ChatCompletionStream,
+// see ChatCompletionStream.Demux.
+type ChatCompletionChoiceStream struct {
+	// Index is the index of the choice this stream delivers.
+	Index int
+
+	demux *chatCompletionStreamDemux
+	// ready is signaled when a chunk is queued or the upstream ends.
+	ready  chan struct{}
+	closed chan struct{}
+
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	queue  []ChatCompletionStreamChoice
+	done   bool
+	choice *accumulatedChoice
+}
+
+type chatCompletionStreamDemux struct {
+	stream  *ChatCompletionStream
+	streams []*ChatCompletionChoiceStream
+
+	// err is set before the choice streams are marked done.
+	err error
+
+	mu   sync.Mutex
+	open int
+}
+
+// Demux splits the stream into one stream per choice of the request, which is useful
+// if ChatCompletionRequest.N is greater than 1. The upstream is read as fast as it delivers
+// and every choice stream queues its chunks until they are received, so that the choices
+// can be consumed in any order, e.g. one after the other. A choice stream that is not
+// consumed should be closed to discard its chunks. The upstream is closed once every choice
+// stream is closed or the upstream ends. The stream must not be consumed directly after
+// calling Demux.
+func (stream *ChatCompletionStream) Demux() []*ChatCompletionChoiceStream {
+	n := max(stream.request.N, 1)
+	demux := &chatCompletionStreamDemux{
+		stream:  stream,
+		streams: make([]*ChatCompletionChoiceStream, n),
+		open:    n,
+	}
+	for i := range demux.streams {
+		demux.streams[i] = &ChatCompletionChoiceStream{
+			Index:  i,
+			demux:  demux,
+			ready:  make(chan struct{}, 1),
+			closed: make(chan struct{}),
+			choice: &accumulatedChoice{index: i, toolIndex: map[int]int{}},
+		}
+	}
+
+	go demux.run()
+	return demux.streams
+}
+
+func (d *chatCompletionStreamDemux) run() {
+	defer func() {
+		_ = d.stream.Close()
+		for _, s := range d.streams {
+			s.mu.Lock()
+			s.done = true
+			s.mu.Unlock()
+			s.signal()
+		}
+	}()
+
+	for {
+		chunk, err := d.stream.Recv()
+		if err != nil {
+			d.err = err
+			return
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index < 0 || choice.Index >= len(d.streams) {
+				continue
+			}
+			d.streams[choice.Index].push(choice)
+		}
+	}
+}
+
+// push queues a chunk of the choice unless the choice stream is closed.
+func (s *ChatCompletionChoiceStream) push(choice ChatCompletionStreamChoice) {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	s.queue = append(s.queue, choice)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// signal wakes up a pending Recv without blocking.
+func (s *ChatCompletionChoiceStream) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Recv returns the next chunk of the choice, or io.EOF once the stream is finished.
+func (s *ChatCompletionChoiceStream) Recv() (ChatCompletionStreamChoice, error) {
+	for {
+		select {
+		case <-s.closed:
+			return ChatCompletionStreamChoice{}, io.EOF
+		default:
+		}
+
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			choice := s.queue[0]
+			s.queue[0] = ChatCompletionStreamChoice{}
+			s.queue = s.queue[1:]
+			s.choice.add(choice)
+			s.mu.Unlock()
+			return choice, nil
+		}
+		done := s.done
+		s.mu.Unlock()
+		if done {
+			return ChatCompletionStreamChoice{}, s.demux.err
+		}
+
+		select {
+		case <-s.closed:
+		case <-s.ready:
+		}
+	}
+}
+
+// Choice returns the choice accumulated from the chunks received so far.
+func (s *ChatCompletionChoiceStream) Choice() ChatCompletionChoice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.choice.snapshot()
+}
+
+// Close detaches the choice stream. Its remaining chunks are discarded.
+func (s *ChatCompletionChoiceStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		close(s.closed)
+		s.queue = nil
+		s.mu.Unlock()
+
+		d := s.demux
+		d.mu.Lock()
+		d.open--
+		last := d.open == 0
+		d.mu.Unlock()
+
+		if last {
+			err = d.stream.Close()
+		}
+	})
+	return err
+}
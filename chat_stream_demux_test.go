@@ -0,0 +1,108 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDemuxConsumesChoicesOneAfterTheOther(t *testing.T) {
+	const chunks = 200
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range chunks {
+			for index := range 2 {
+				data, _ := marshalEventData(newStreamChunkData(contentChunk(index, fmt.Sprint(i%10))))
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+		}
+		for index := range 2 {
+			data, _ := marshalEventData(newStreamChunkData(finishChunk(index)))
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}), nil)
+	stream, err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{Model: GPT4o, N: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	choices := stream.Demux()
+	if len(choices) != 2 {
+		t.Fatalf("got %d choice streams, want 2", len(choices))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		for _, choice := range choices {
+			var received int
+			for {
+				_, err := choice.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					done <- err
+					return
+				}
+				received++
+			}
+			if received != chunks+1 {
+				done <- fmt.Errorf("choice %d received %d chunks, want %d", choice.Index, received, chunks+1)
+				return
+			}
+			if got := choice.Choice(); len(got.Message.Content) != chunks || got.FinishReason != FinishReasonStop {
+				done <- fmt.Errorf("choice %d accumulated %d characters and finish reason %q", choice.Index, len(got.Message.Content), got.FinishReason)
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("consuming the choices one after the other did not finish")
+	}
+}
+
+func TestDemuxDiscardsClosedChoice(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []ChatCompletionStreamResponse{
+			contentChunk(0, "a"), contentChunk(1, "x"), contentChunk(0, "b"), finishChunk(0), finishChunk(1),
+		} {
+			data, _ := marshalEventData(newStreamChunkData(chunk))
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}), nil)
+	stream, err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{Model: GPT4o, N: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	choices := stream.Demux()
+	if err := choices[1].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := choices[1].Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("got error %v from a closed choice stream, want EOF", err)
+	}
+
+	for {
+		if _, err := choices[0].Recv(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := choices[0].Choice().Message.Content; got != "ab" {
+		t.Errorf("got content %q, want %q", got, "ab")
+	}
+}
@@ -0,0 +1,174 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+)
+
+// ChatCompletionStreamHandler receives the parts of a chat completion stream as they arrive,
+// see ChatCompletionStream.Handle. Embed BaseChatCompletionStreamHandler to implement only
+// the callbacks of interest.
+//
+// The callbacks are called in the order of the chunks, and for each choice:
+//   - OnRole is called at most once, before any other callback of the choice.
+//   - OnToolCallStart is called once per tool call, before its OnToolCallArgumentsDelta calls.
+//   - OnToolCallDone is called with the complete tool call when the next tool call of the
+//     choice starts, the choice finishes or the stream ends, before the next OnToolCallStart.
+//   - OnFinish is called at most once, after all other callbacks of the choice.
+//
+// OnUsage is called when the server sends the usage, see StreamOptions.IncludeUsage.
+// OnError is called once if the stream fails, and no callback is called after it. The
+// deprecated function calls are not reported, but they are part of the returned response.
+type ChatCompletionStreamHandler interface {
+	OnRole(choiceIndex int, role string)
+	OnContentDelta(choiceIndex int, delta string)
+	OnRefusalDelta(choiceIndex int, delta string)
+	// OnToolCallStart is called with the ID, type and name carried by the first fragment of
+	// a tool call. toolCallIndex is the position of the tool call in the message.
+	OnToolCallStart(choiceIndex, toolCallIndex int, toolCall ToolCall)
+	OnToolCallArgumentsDelta(choiceIndex, toolCallIndex int, delta string)
+	OnToolCallDone(choiceIndex, toolCallIndex int, toolCall ToolCall)
+	OnUsage(usage Usage)
+	OnFinish(choiceIndex int, reason FinishReason)
+	OnError(err error)
+}
+
+// BaseChatCompletionStreamHandler implements ChatCompletionStreamHandler with callbacks
+// that do nothing.
+type BaseChatCompletionStreamHandler struct{}
+
+func (BaseChatCompletionStreamHandler) OnRole(int, string)                        {}
+func (BaseChatCompletionStreamHandler) OnContentDelta(int, string)                {}
+func (BaseChatCompletionStreamHandler) OnRefusalDelta(int, string)                {}
+func (BaseChatCompletionStreamHandler) OnToolCallStart(int, int, ToolCall)        {}
+func (BaseChatCompletionStreamHandler) OnToolCallArgumentsDelta(int, int, string) {}
+func (BaseChatCompletionStreamHandler) OnToolCallDone(int, int, ToolCall)         {}
+func (BaseChatCompletionStreamHandler) OnUsage(Usage)                             {}
+func (BaseChatCompletionStreamHandler) OnFinish(int, FinishReason)                {}
+func (BaseChatCompletionStreamHandler) OnError(error)                             {}
+
+// handledChoice tracks the callbacks made for a choice.
+type handledChoice struct {
+	roleSent bool
+	finished bool
+	// toolCalls accumulates the tool calls and open is the position of the one in progress, or -1.
+	toolCalls *accumulatedChoice
+	open      int
+}
+
+// Handle receives the remaining chunks of the stream, calls the handler for their parts and
+// returns the accumulated response. It returns the error the stream failed with after
+// calling OnError. The stream is not closed.
+func (stream *ChatCompletionStream) Handle(handler ChatCompletionStreamHandler) (ChatCompletionResponse, error) {
+	accumulator := NewChatCompletionStreamAccumulator(stream)
+	choices := map[int]*handledChoice{}
+
+	for {
+		chunk, err := accumulator.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			handler.OnError(err)
+			return accumulator.Snapshot(), err
+		}
+
+		for _, choice := range chunk.Choices {
+			state := choices[choice.Index]
+			if state == nil {
+				state = &handledChoice{
+					toolCalls: &accumulatedChoice{index: choice.Index, toolIndex: map[int]int{}},
+					open:      -1,
+				}
+				choices[choice.Index] = state
+			}
+			state.handle(handler, choice)
+		}
+		if chunk.Usage != (Usage{}) {
+			handler.OnUsage(chunk.Usage)
+		}
+	}
+
+	for _, index := range slices.Sorted(maps.Keys(choices)) {
+		choices[index].closeToolCall(handler, index)
+	}
+	return accumulator.Snapshot(), nil
+}
+
+func (c *handledChoice) handle(handler ChatCompletionStreamHandler, choice ChatCompletionStreamChoice) {
+	if c.finished {
+		return
+	}
+
+	delta := choice.Delta
+	if delta.Role != "" && !c.roleSent {
+		c.roleSent = true
+		handler.OnRole(choice.Index, delta.Role)
+	}
+	if delta.Content != "" {
+		handler.OnContentDelta(choice.Index, delta.Content)
+	}
+	if delta.Refusal != "" {
+		handler.OnRefusalDelta(choice.Index, delta.Refusal)
+	}
+
+	for _, fragment := range delta.ToolCalls {
+		known := len(c.toolCalls.toolCalls)
+		pos := c.toolCalls.addToolCall(fragment)
+		if pos >= known {
+			c.closeToolCall(handler, choice.Index)
+			c.open = pos
+			handler.OnToolCallStart(choice.Index, pos, c.toolCall(pos))
+		}
+		if fragment.Function.Arguments != "" {
+			handler.OnToolCallArgumentsDelta(choice.Index, pos, fragment.Function.Arguments)
+		}
+	}
+
+	if choice.FinishReason != "" && choice.FinishReason != FinishReasonNull {
+		c.closeToolCall(handler, choice.Index)
+		c.finished = true
+		handler.OnFinish(choice.Index, choice.FinishReason)
+	}
+}
+
+func (c *handledChoice) closeToolCall(handler ChatCompletionStreamHandler, choiceIndex int) {
+	if c.open < 0 {
+		return
+	}
+	pos := c.open
+	c.open = -1
+	handler.OnToolCallDone(choiceIndex, pos, c.toolCall(pos))
+}
+
+func (c *handledChoice) toolCall(pos int) ToolCall {
+	toolCall := c.toolCalls.toolCalls[pos]
+	toolCall.Index = &pos
+	if toolCall.Type == "" {
+		toolCall.Type = ToolTypeFunction
+	}
+	return toolCall
+}
+
+// CreateChatCompletionWithHandler creates a chat completion stream, drives handler with it
+// and returns the accumulated response, see ChatCompletionStream.Handle. If the stream cannot
+// be created, OnError is called with the error.
+func (c *Client) CreateChatCompletionWithHandler(
+	ctx context.Context,
+	request ChatCompletionRequest,
+	headers map[string]string,
+	handler ChatCompletionStreamHandler,
+	retryOpts ...RetryOptions,
+) (ChatCompletionResponse, error) {
+	stream, err := c.CreateChatCompletionStream(ctx, request, headers, retryOpts...)
+	if err != nil {
+		handler.OnError(err)
+		return ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	return stream.Handle(handler)
+}
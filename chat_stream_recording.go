@@ -0,0 +1,346 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A recording consists of records, each of which is a line with the record kind, the
+// time it was received and the length of its payload, followed by the payload verbatim
+// and a newline:
+//
+//	HEADER 2024-05-13T10:00:00.000000000Z 64
+//	HTTP/1.1 200 OK
+//	Content-Type: text/event-stream
+//
+//	DATA 2024-05-13T10:00:00.250000000Z 31
+//	data: {"id":"chatcmpl-123",...}
+//
+// A recording starts with a REQUEST record holding the parameters of the request that
+// replaying the stream depends on as JSON. A HEADER record starts the response of every
+// connection; a resumed stream has several. An ERROR record holds the message of the error
+// reading a response failed with.
+
+const (
+	recordRequest = "REQUEST"
+	recordHeader  = "HEADER"
+	recordData    = "DATA"
+	recordError   = "ERROR"
+)
+
+var ErrRecordingStarted = errors.New("recording must start before the first chunk is received")
+
+// recordedRequest holds the parameters of a recorded request that are restored on replay.
+type recordedRequest struct {
+	N int `json:"n,omitempty"`
+}
+
+// streamRecorder writes the records of a stream to a writer. It stops recording after
+// the first write error.
+type streamRecorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+func (r *streamRecorder) write(kind string, at time.Time, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+
+	_, err := fmt.Fprintf(r.w, "%s %s %d\n%s\n", kind, at.UTC().Format(time.RFC3339Nano), len(payload), payload)
+	if err != nil {
+		r.failed = true
+		slog.Error("failed to record chat completion stream, recording stopped", "err", err)
+	}
+}
+
+type recordedData struct {
+	kind string
+	at   time.Time
+	data []byte
+}
+
+// maxEarlyRecording is the number of bytes a stream keeps for a late start of the recording.
+// Recording cannot start anymore once more were read.
+const maxEarlyRecording = 64 << 10
+
+// streamTee passes the raw bytes of a response body on to a recorder. Until recording
+// starts or is ruled out, it keeps the bytes read so far so that they can be recorded late.
+type streamTee struct {
+	body io.ReadCloser
+
+	mu       sync.Mutex
+	recorder *streamRecorder
+	early    []recordedData
+	// earlySize is the number of bytes in early.
+	earlySize int
+	buffer    bool
+	// lastRead is the time data was last read from the body.
+	lastRead time.Time
+}
+
+// newStreamTee creates a tee for body. If buffer is set, the bytes read are kept until
+// stopBuffering is called.
+func newStreamTee(body io.ReadCloser, buffer bool) *streamTee {
+	return &streamTee{
+		body:   body,
+		buffer: buffer,
+	}
+}
+
+func (t *streamTee) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+	now := time.Now()
+	if n > 0 {
+		t.add(recordData, now, p[:n])
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.add(recordError, now, []byte(err.Error()))
+	}
+	return n, err
+}
+
+func (t *streamTee) add(kind string, now time.Time, payload []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if kind == recordData {
+		t.lastRead = now
+	}
+
+	switch {
+	case t.recorder != nil:
+		t.recorder.write(kind, now, payload)
+	case t.buffer && t.earlySize+len(payload) > maxEarlyRecording:
+		t.buffer = false
+		t.early, t.earlySize = nil, 0
+	case t.buffer:
+		t.early = append(t.early, recordedData{kind: kind, at: now, data: bytes.Clone(payload)})
+		t.earlySize += len(payload)
+	}
+}
+
+func (t *streamTee) Close() error {
+	return t.body.Close()
+}
+
+// record writes the response headers and the bytes read so far, and records all further reads.
+func (t *streamTee) record(recorder *streamRecorder, resp *http.Response, headersAt time.Time) {
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	_ = resp.Header.Write(&header)
+	header.WriteString("\r\n")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	recorder.write(recordHeader, headersAt, header.Bytes())
+	for _, early := range t.early {
+		recorder.write(early.kind, early.at, early.data)
+	}
+	t.early, t.earlySize = nil, 0
+	t.recorder = recorder
+}
+
+// readAt returns the time data was last read from the body.
+func (t *streamTee) readAt() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastRead
+}
+
+// stopBuffering discards the bytes kept for a late start of the recording.
+func (t *streamTee) stopBuffering() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buffer = false
+	t.early, t.earlySize = nil, 0
+}
+
+// Record writes the raw server-sent events received by the stream to w, together with the
+// response headers and the time every part was received, while the stream is consumed as
+// usual. It must be called before the first call to Recv, and before the stream has read
+// more than 64 KiB, which only happens if the first chunk is that large. The recording can be replayed
+// with NewChatCompletionStreamFromRecording.
+func (stream *ChatCompletionStream) Record(w io.Writer) error {
+	if !stream.tee.buffering() {
+		return ErrRecordingStarted
+	}
+
+	request, err := json.Marshal(recordedRequest{N: stream.request.N})
+	if err != nil {
+		return err
+	}
+	stream.recorder = &streamRecorder{w: w}
+	stream.recorder.write(recordRequest, time.Now(), request)
+	stream.tee.record(stream.recorder, stream.response, stream.headersAt)
+	return nil
+}
+
+func (t *streamTee) buffering() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buffer
+}
+
+// NewChatCompletionStreamFromRecording creates a stream that replays a recording written by
+// ChatCompletionStream.Record. If realtime is set, the chunks are delivered with the timing
+// they were recorded with, otherwise as fast as they are read. If the recorded stream was
+// resumed, every connection is replayed in turn and resumed with the given strategy, which
+// should match the one the stream was recorded with.
+func NewChatCompletionStreamFromRecording(
+	recording io.Reader,
+	realtime bool,
+	strategy StreamResumeStrategy,
+) (*ChatCompletionStream, error) {
+	request, responses, err := readRecording(recording, realtime)
+	if err != nil {
+		return nil, err
+	}
+
+	open := func(ChatCompletionRequest) (*streamReader[ChatCompletionStreamResponse], error) {
+		if len(responses) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		resp := responses[0]
+		responses = responses[1:]
+		reader := newStreamReader[ChatCompletionStreamResponse](resp, defaultEmptyMessagesLimit)
+		reader.sentAt, reader.headersAt = time.Now(), time.Now()
+		return reader, nil
+	}
+
+	options := NewDefaultRetryOptions()
+	options.StreamResumes = len(responses) - 1
+	options.StreamResumeStrategy = strategy
+
+	reader, _ := open(ChatCompletionRequest{})
+	stream := &ChatCompletionStream{
+		streamReader: reader,
+		request:      ChatCompletionRequest{N: request.N},
+		options:      options,
+		open:         open,
+		stats:        newStreamStatsCollector(reader.sentAt, reader.headersAt, nil),
+	}
+	if options.StreamResumes > 0 {
+		stream.delivered = NewChatCompletionStreamAccumulator(nil)
+	}
+	return stream, nil
+}
+
+func readRecording(recording io.Reader, realtime bool) (recordedRequest, []*http.Response, error) {
+	r := bufio.NewReader(recording)
+
+	var (
+		request   recordedRequest
+		responses []*http.Response
+		current   *replayBody
+	)
+	for {
+		var (
+			kind   string
+			at     string
+			length int
+		)
+		_, err := fmt.Fscanf(r, "%s %s %d\n", &kind, &at, &length)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return request, nil, fmt.Errorf("invalid recording: %w", err)
+		}
+
+		timestamp, err := time.Parse(time.RFC3339Nano, at)
+		if err != nil {
+			return request, nil, fmt.Errorf("invalid recording: %w", err)
+		}
+		payload := make([]byte, length+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return request, nil, fmt.Errorf("invalid recording: %w", err)
+		}
+		payload = payload[:length]
+
+		switch kind {
+		case recordRequest:
+			if err := json.Unmarshal(payload, &request); err != nil {
+				return request, nil, fmt.Errorf("invalid recording: %w", err)
+			}
+		case recordHeader:
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(payload)), nil)
+			if err != nil {
+				return request, nil, fmt.Errorf("invalid recording: %w", err)
+			}
+			current = &replayBody{start: timestamp, realtime: realtime}
+			resp.Body = current
+			responses = append(responses, resp)
+		case recordData, recordError:
+			if current == nil {
+				return request, nil, errors.New("invalid recording: data before header")
+			}
+			current.records = append(current.records, recordedData{kind: kind, at: timestamp, data: payload})
+		default:
+			return request, nil, fmt.Errorf("invalid recording: unknown record %q", kind)
+		}
+	}
+
+	if len(responses) == 0 {
+		return request, nil, errors.New("invalid recording: no response")
+	}
+	return request, responses, nil
+}
+
+// replayBody is a response body replaying recorded data.
+type replayBody struct {
+	records  []recordedData
+	start    time.Time
+	realtime bool
+
+	replayStart time.Time
+	pending     []byte
+	closed      atomic.Bool
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	if b.closed.Load() {
+		return 0, errors.New("read on closed body")
+	}
+
+	if len(b.pending) == 0 {
+		if len(b.records) == 0 {
+			return 0, io.EOF
+		}
+
+		record := b.records[0]
+		b.records = b.records[1:]
+		if b.realtime {
+			if b.replayStart.IsZero() {
+				b.replayStart = time.Now()
+			}
+			time.Sleep(time.Until(b.replayStart.Add(record.at.Sub(b.start))))
+		}
+		if record.kind == recordError {
+			b.records = nil
+			return 0, errors.New(string(record.data))
+		}
+		b.pending = record.data
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *replayBody) Close() error {
+	b.closed.Store(true)
+	return nil
+}
@@ -0,0 +1,313 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// StreamResumeStrategy selects how a chat completion stream that broke mid-way is re-established.
+type StreamResumeStrategy string
+
+const (
+	// StreamResumeStrategyRestart re-issues the original request with the same seed. The
+	// regenerated chunks that were already delivered are deduplicated.
+	StreamResumeStrategyRestart StreamResumeStrategy = "restart"
+	// StreamResumeStrategyPrefill re-issues the request with the assistant text received so far
+	// appended as the last message, so that providers supporting assistant prefill continue
+	// where the stream broke. It falls back to a restart for n>1, function and tool calls.
+	StreamResumeStrategyPrefill StreamResumeStrategy = "prefill"
+)
+
+var ErrStreamResumeDiverged = errors.New("resumed stream diverged from the chunks already received")
+
+// ChatCompletionStreamResume describes how a chunk relates to a resumed stream.
+type ChatCompletionStreamResume struct {
+	// Attempt is the number of times the stream has been resumed so far.
+	Attempt int
+	// Strategy is the strategy that was used to resume the stream.
+	Strategy StreamResumeStrategy
+	// Trimmed is set when a part of the chunk was already delivered before the stream
+	// broke and was removed from it.
+	Trimmed bool
+	// Skipped is the number of replayed chunks that were dropped since the stream was
+	// resumed because they had been delivered already.
+	Skipped int
+}
+
+type streamReplay struct {
+	info ChatCompletionStreamResume
+
+	// delivered is what the caller received before the stream was resumed, replayed
+	// is what the new stream has sent so far. Both are nil for a prefill.
+	delivered *ChatCompletionResponse
+	replayed  *ChatCompletionStreamAccumulator
+}
+
+func (stream *ChatCompletionStream) canResume(err error) bool {
+	if stream.delivered == nil || stream.resumes >= stream.options.StreamResumes {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) ||
+		errors.Is(err, ErrTooManyEmptyStreamMessages) ||
+		errors.Is(err, ErrStreamResumeDiverged) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if req := stream.response.Request; req != nil && req.Context().Err() != nil {
+		return false
+	}
+	if stream.isClosed() {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) {
+		// the connection was closed without [DONE], which is fine if every choice has finished
+		if stream.isFinished {
+			return false
+		}
+		choices := stream.delivered.Snapshot().Choices
+		return len(choices) == 0 || slices.ContainsFunc(choices, func(c ChatCompletionChoice) bool {
+			return c.FinishReason == ""
+		})
+	}
+	return true
+}
+
+func (stream *ChatCompletionStream) resume(cause error) error {
+	stream.resumes++
+	_ = stream.reader().Close()
+
+	delivered := stream.delivered.Snapshot()
+	request := stream.request
+	replay := &streamReplay{
+		info: ChatCompletionStreamResume{
+			Attempt:  stream.resumes,
+			Strategy: StreamResumeStrategyRestart,
+		},
+	}
+
+	if stream.options.StreamResumeStrategy == StreamResumeStrategyPrefill && canPrefill(delivered) {
+		replay.info.Strategy = StreamResumeStrategyPrefill
+		request.Messages = append(slices.Clone(request.Messages), ChatCompletionMessage{
+			Role:    ChatMessageRoleAssistant,
+			Content: delivered.Choices[0].Message.Content,
+		})
+	} else {
+		replay.delivered = &delivered
+		replay.replayed = NewChatCompletionStreamAccumulator(nil)
+	}
+
+	slog.Warn("resuming broken chat completion stream", "attempt", stream.resumes, "maxAttempts", stream.options.StreamResumes, "strategy", replay.info.Strategy, "cause", cause)
+
+	reader, err := stream.open(request)
+	if err != nil {
+		return err
+	}
+
+	if stream.recorder != nil {
+		reader.tee.record(stream.recorder, reader.response, reader.headersAt)
+	}
+	reader.tee.stopBuffering()
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.closed {
+		_ = reader.Close()
+		return cause
+	}
+	stream.streamReader = reader
+	stream.replay = replay
+	return nil
+}
+
+// reader returns the reader of the current connection, which resume replaces.
+func (stream *ChatCompletionStream) reader() *streamReader[ChatCompletionStreamResponse] {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return stream.streamReader
+}
+
+func (stream *ChatCompletionStream) isClosed() bool {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return stream.closed
+}
+
+func canPrefill(delivered ChatCompletionResponse) bool {
+	if len(delivered.Choices) != 1 {
+		return false
+	}
+	message := delivered.Choices[0].Message
+	return message.Content != "" && message.Refusal == "" && message.FunctionCall == nil && len(message.ToolCalls) == 0
+}
+
+// filter removes the parts of a chunk that were delivered before the stream was resumed.
+// It reports false if nothing is left of the chunk.
+func (r *streamReplay) filter(chunk ChatCompletionStreamResponse) (ChatCompletionStreamResponse, bool, error) {
+	if r.delivered == nil {
+		info := r.info
+		chunk.Resume = &info
+		return chunk, true, nil
+	}
+
+	before := r.replayed.Snapshot()
+	r.replayed.Add(chunk)
+
+	var trimmed bool
+	choices := make([]ChatCompletionStreamChoice, 0, len(chunk.Choices))
+	for _, choice := range chunk.Choices {
+		delivered := findChoice(r.delivered.Choices, choice.Index)
+		if delivered == nil {
+			choices = append(choices, choice)
+			continue
+		}
+
+		replayed := findChoice(before.Choices, choice.Index)
+		if replayed == nil {
+			replayed = &ChatCompletionChoice{}
+		}
+
+		filtered, ok := filterChoice(choice, delivered, replayed)
+		if !ok {
+			return ChatCompletionStreamResponse{}, false, ErrStreamResumeDiverged
+		}
+		if !isEmptyChoice(filtered) {
+			choices = append(choices, filtered)
+		}
+		trimmed = trimmed || !reflect.DeepEqual(choice, filtered)
+	}
+	chunk.Choices = choices
+
+	if len(chunk.Choices) == 0 && chunk.Usage == (Usage{}) {
+		r.info.Skipped++
+		return chunk, false, nil
+	}
+
+	info := r.info
+	info.Trimmed = trimmed
+	chunk.Resume = &info
+	return chunk, true, nil
+}
+
+func filterChoice(choice ChatCompletionStreamChoice, delivered, replayed *ChatCompletionChoice) (ChatCompletionStreamChoice, bool) {
+	var ok bool
+	delta := choice.Delta
+	if delivered.Message.Role != "" {
+		delta.Role = ""
+	}
+
+	delta.Content, ok = unseenSuffix(delivered.Message.Content, replayed.Message.Content, delta.Content)
+	if !ok {
+		return choice, false
+	}
+	delta.Refusal, ok = unseenSuffix(delivered.Message.Refusal, replayed.Message.Refusal, delta.Refusal)
+	if !ok {
+		return choice, false
+	}
+	if delta.Content == "" {
+		// the log probabilities belong to the content that was already delivered
+		choice.LogProbs = nil
+	}
+
+	if delta.FunctionCall != nil && delivered.Message.FunctionCall != nil {
+		var prev FunctionCall
+		if replayed.Message.FunctionCall != nil {
+			prev = *replayed.Message.FunctionCall
+		}
+		functionCall, ok := filterFunctionCall(*delta.FunctionCall, *delivered.Message.FunctionCall, prev)
+		if !ok {
+			return choice, false
+		}
+		delta.FunctionCall = nil
+		if functionCall != (FunctionCall{}) {
+			delta.FunctionCall = &functionCall
+		}
+	}
+
+	if len(delta.ToolCalls) > 0 {
+		toolCalls := make([]ToolCall, 0, len(delta.ToolCalls))
+		for i, toolCall := range delta.ToolCalls {
+			pos := len(replayed.Message.ToolCalls) - 1
+			if toolCall.Index != nil {
+				pos = *toolCall.Index
+			} else if pos < 0 || (toolCall.ID != "" && toolCall.ID != replayed.Message.ToolCalls[pos].ID) {
+				pos++
+			}
+			if pos < 0 || pos >= len(delivered.Message.ToolCalls) {
+				toolCalls = append(toolCalls, delta.ToolCalls[i:]...)
+				break
+			}
+
+			var prev FunctionCall
+			if pos < len(replayed.Message.ToolCalls) {
+				prev = replayed.Message.ToolCalls[pos].Function
+			}
+			function, ok := filterFunctionCall(toolCall.Function, delivered.Message.ToolCalls[pos].Function, prev)
+			if !ok {
+				return choice, false
+			}
+			if function == (FunctionCall{}) {
+				continue
+			}
+			// the regenerated tool call gets a new ID, keep the one that was delivered
+			toolCall.ID = ""
+			toolCall.Type = ""
+			toolCall.Function = function
+			toolCalls = append(toolCalls, toolCall)
+		}
+		delta.ToolCalls = toolCalls
+	}
+
+	if delivered.FinishReason != "" && delivered.FinishReason == choice.FinishReason {
+		choice.FinishReason = ""
+	}
+	choice.Delta = delta
+	return choice, true
+}
+
+func filterFunctionCall(fragment, delivered, replayed FunctionCall) (FunctionCall, bool) {
+	var nameOK, argsOK bool
+	fragment.Name, nameOK = unseenSuffix(delivered.Name, replayed.Name, fragment.Name)
+	fragment.Arguments, argsOK = unseenSuffix(delivered.Arguments, replayed.Arguments, fragment.Arguments)
+	return fragment, nameOK && argsOK
+}
+
+// unseenSuffix returns the part of delta that extends past delivered, given that
+// replayed was received before delta. It reports false if the replayed text does not
+// match the delivered text.
+func unseenSuffix(delivered, replayed, delta string) (string, bool) {
+	if len(replayed) >= len(delivered) {
+		return delta, true
+	}
+	combined := replayed + delta
+	if len(combined) <= len(delivered) {
+		return "", strings.HasPrefix(delivered, combined)
+	}
+	if !strings.HasPrefix(combined, delivered) {
+		return "", false
+	}
+	return combined[len(delivered):], true
+}
+
+func findChoice(choices []ChatCompletionChoice, index int) *ChatCompletionChoice {
+	for i := range choices {
+		if choices[i].Index == index {
+			return &choices[i]
+		}
+	}
+	return nil
+}
+
+func isEmptyChoice(choice ChatCompletionStreamChoice) bool {
+	delta := choice.Delta
+	return delta.Role == "" && delta.Content == "" && delta.Refusal == "" && delta.FunctionCall == nil && len(delta.ToolCalls) == 0 &&
+		(choice.FinishReason == "" || choice.FinishReason == FinishReasonNull)
+}
@@ -0,0 +1,294 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func contentChunk(index int, content string) ChatCompletionStreamResponse {
+	return ChatCompletionStreamResponse{
+		ID:      "chatcmpl-123",
+		Choices: []ChatCompletionStreamChoice{{Index: index, Delta: ChatCompletionStreamChoiceDelta{Content: content}}},
+	}
+}
+
+func finishChunk(index int) ChatCompletionStreamResponse {
+	return ChatCompletionStreamResponse{
+		ID:      "chatcmpl-123",
+		Choices: []ChatCompletionStreamChoice{{Index: index, FinishReason: FinishReasonStop}},
+	}
+}
+
+func toolCallChunk(toolIndex int, id, name, arguments string) ChatCompletionStreamResponse {
+	toolCall := ToolCall{Index: &toolIndex, ID: id, Function: FunctionCall{Name: name, Arguments: arguments}}
+	if id != "" {
+		toolCall.Type = ToolTypeFunction
+	}
+	return ChatCompletionStreamResponse{
+		ID:      "chatcmpl-123",
+		Choices: []ChatCompletionStreamChoice{{Delta: ChatCompletionStreamChoiceDelta{ToolCalls: []ToolCall{toolCall}}}},
+	}
+}
+
+func withResume(chunk ChatCompletionStreamResponse, strategy StreamResumeStrategy, trimmed bool, skipped int) ChatCompletionStreamResponse {
+	chunk.Resume = &ChatCompletionStreamResume{Attempt: 1, Strategy: strategy, Trimmed: trimmed, Skipped: skipped}
+	return chunk
+}
+
+func TestStreamReplayFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  StreamResumeStrategy
+		delivered []ChatCompletionStreamResponse
+		replayed  []ChatCompletionStreamResponse
+		want      []ChatCompletionStreamResponse
+		wantErr   error
+	}{
+		{
+			name:      "restart with identical replay",
+			strategy:  StreamResumeStrategyRestart,
+			delivered: []ChatCompletionStreamResponse{contentChunk(0, "Hel"), contentChunk(0, "lo")},
+			replayed: []ChatCompletionStreamResponse{
+				contentChunk(0, "Hel"), contentChunk(0, "lo"), contentChunk(0, " world"), finishChunk(0),
+			},
+			want: []ChatCompletionStreamResponse{
+				withResume(contentChunk(0, " world"), StreamResumeStrategyRestart, false, 2),
+				withResume(finishChunk(0), StreamResumeStrategyRestart, false, 2),
+			},
+		},
+		{
+			name:      "restart with different chunk boundaries",
+			strategy:  StreamResumeStrategyRestart,
+			delivered: []ChatCompletionStreamResponse{contentChunk(0, "Hel"), contentChunk(0, "lo")},
+			replayed:  []ChatCompletionStreamResponse{contentChunk(0, "He"), contentChunk(0, "llo wor"), contentChunk(0, "ld")},
+			want: []ChatCompletionStreamResponse{
+				withResume(contentChunk(0, " wor"), StreamResumeStrategyRestart, true, 1),
+				withResume(contentChunk(0, "ld"), StreamResumeStrategyRestart, false, 1),
+			},
+		},
+		{
+			name:      "restart that diverges",
+			strategy:  StreamResumeStrategyRestart,
+			delivered: []ChatCompletionStreamResponse{contentChunk(0, "Hello")},
+			replayed:  []ChatCompletionStreamResponse{contentChunk(0, "Hi"), contentChunk(0, " there")},
+			wantErr:   ErrStreamResumeDiverged,
+		},
+		{
+			name:      "prefill continuation",
+			strategy:  StreamResumeStrategyPrefill,
+			delivered: []ChatCompletionStreamResponse{contentChunk(0, "Hello")},
+			replayed:  []ChatCompletionStreamResponse{contentChunk(0, " world"), finishChunk(0)},
+			want: []ChatCompletionStreamResponse{
+				withResume(contentChunk(0, " world"), StreamResumeStrategyPrefill, false, 0),
+				withResume(finishChunk(0), StreamResumeStrategyPrefill, false, 0),
+			},
+		},
+		{
+			name:      "n>1",
+			strategy:  StreamResumeStrategyRestart,
+			delivered: []ChatCompletionStreamResponse{contentChunk(0, "ab"), contentChunk(1, "x")},
+			replayed: []ChatCompletionStreamResponse{
+				contentChunk(0, "a"), contentChunk(1, "xy"), contentChunk(0, "bc"), contentChunk(2, "new"),
+			},
+			want: []ChatCompletionStreamResponse{
+				withResume(contentChunk(1, "y"), StreamResumeStrategyRestart, true, 1),
+				withResume(contentChunk(0, "c"), StreamResumeStrategyRestart, true, 1),
+				withResume(contentChunk(2, "new"), StreamResumeStrategyRestart, false, 1),
+			},
+		},
+		{
+			name:     "partially delivered tool calls",
+			strategy: StreamResumeStrategyRestart,
+			delivered: []ChatCompletionStreamResponse{
+				toolCallChunk(0, "call_1", "get_weather", ""), toolCallChunk(0, "", "", `{"city":`),
+			},
+			replayed: []ChatCompletionStreamResponse{
+				toolCallChunk(0, "call_2", "get_weather", ""),
+				toolCallChunk(0, "", "", `{"city":"Paris"`),
+				toolCallChunk(0, "", "", `}`),
+				toolCallChunk(1, "call_3", "get_time", `{}`),
+			},
+			want: []ChatCompletionStreamResponse{
+				// the regenerated call keeps the ID that was delivered
+				withResume(toolCallChunk(0, "", "", `"Paris"`), StreamResumeStrategyRestart, true, 1),
+				withResume(toolCallChunk(0, "", "", `}`), StreamResumeStrategyRestart, false, 1),
+				withResume(toolCallChunk(1, "call_3", "get_time", `{}`), StreamResumeStrategyRestart, false, 1),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accumulator := NewChatCompletionStreamAccumulator(nil)
+			for _, chunk := range tt.delivered {
+				accumulator.Add(chunk)
+			}
+			delivered := accumulator.Snapshot()
+
+			replay := &streamReplay{info: ChatCompletionStreamResume{Attempt: 1, Strategy: tt.strategy}}
+			if tt.strategy == StreamResumeStrategyRestart {
+				replay.delivered = &delivered
+				replay.replayed = NewChatCompletionStreamAccumulator(nil)
+			}
+
+			var got []ChatCompletionStreamResponse
+			for _, chunk := range tt.replayed {
+				filtered, keep, err := replay.filter(chunk)
+				if err != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("got error %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+				if keep {
+					got = append(got, filtered)
+				}
+			}
+			if tt.wantErr != nil {
+				t.Fatalf("got no error, want %v", tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got chunks\n%s\nwant\n%s", formatChunks(got), formatChunks(tt.want))
+			}
+		})
+	}
+}
+
+func formatChunks(chunks []ChatCompletionStreamResponse) string {
+	var lines []string
+	for _, chunk := range chunks {
+		data, _ := marshalEventData(newStreamChunkData(chunk))
+		lines = append(lines, fmt.Sprintf("%s resume=%+v", data, chunk.Resume))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCanPrefill(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []ChatCompletionStreamResponse
+		want   bool
+	}{
+		{name: "content", chunks: []ChatCompletionStreamResponse{contentChunk(0, "Hello")}, want: true},
+		{name: "no content", chunks: nil, want: false},
+		{name: "n>1", chunks: []ChatCompletionStreamResponse{contentChunk(0, "a"), contentChunk(1, "b")}, want: false},
+		{name: "tool calls", chunks: []ChatCompletionStreamResponse{
+			contentChunk(0, "Let me check."), toolCallChunk(0, "call_1", "get_weather", "{}"),
+		}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accumulator := NewChatCompletionStreamAccumulator(nil)
+			for _, chunk := range tt.chunks {
+				accumulator.Add(chunk)
+			}
+			if got := canPrefill(accumulator.Snapshot()); got != tt.want {
+				t.Errorf("canPrefill() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatCompletionStreamResumesBrokenStream(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{"Hel", "lo", " world"}
+		if requests.Add(1) == 1 {
+			// the connection breaks after two chunks
+			chunks = chunks[:2]
+		}
+		for _, content := range chunks {
+			data, _ := marshalEventData(newStreamChunkData(contentChunk(0, content)))
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if len(chunks) == 3 {
+			data, _ := marshalEventData(newStreamChunkData(finishChunk(0)))
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+		}
+	}), nil)
+	stream, err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil,
+		RetryOptions{StreamResumes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	var resumed int
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+		}
+		if chunk.Resume != nil {
+			resumed++
+		}
+	}
+	if content.String() != "Hello world" {
+		t.Errorf("got content %q, want %q", content.String(), "Hello world")
+	}
+	if resumed != 2 || requests.Load() != 2 {
+		t.Errorf("got %d resumed chunks in %d requests, want 2 in 2", resumed, requests.Load())
+	}
+}
+
+func TestChatCompletionStreamAccumulatorSnapshotWhileResuming(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Request", fmt.Sprint(n))
+		data, _ := marshalEventData(newStreamChunkData(contentChunk(0, "Hello")))
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if n > 1 {
+			data, _ := marshalEventData(newStreamChunkData(finishChunk(0)))
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+		}
+	}), nil)
+	stream, err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil,
+		RetryOptions{StreamResumes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	accumulator := NewChatCompletionStreamAccumulator(stream)
+	stop := make(chan struct{})
+	snapshots := make(chan struct{})
+	go func() {
+		defer close(snapshots)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				accumulator.Snapshot()
+			}
+		}
+	}()
+	_, err = accumulator.Response()
+	close(stop)
+	<-snapshots
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot carries the header of the connection that resumed the stream
+	response := accumulator.Snapshot()
+	if got := response.Header().Get("X-Request"); got != "2" {
+		t.Errorf("got header of request %q, want 2", got)
+	}
+}
@@ -0,0 +1,178 @@
+package openai
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// ChatCompletionStreamStats holds timing data collected while a ChatCompletionStream is consumed.
+type ChatCompletionStreamStats struct {
+	// Start is the time the request was sent.
+	Start time.Time
+	// TimeToHeaders is the time from sending the request to receiving the response headers.
+	TimeToHeaders time.Duration
+	// TimeToFirstChunk is the time from sending the request to receiving the first chunk.
+	TimeToFirstChunk time.Duration
+	// TimeToFirstContent is the time from sending the request to receiving the first chunk
+	// with content, a refusal or a function or tool call.
+	TimeToFirstContent time.Duration
+	// Duration is the time from sending the request to the end of the stream.
+	Duration time.Duration
+
+	// Chunks is the number of chunks received and ContentChunks the number of those
+	// that carried content.
+	Chunks        int
+	ContentChunks int
+	// CompletionTokens is taken from the usage if the server sent it, see
+	// StreamOptions.IncludeUsage. Otherwise it is estimated as the number of content chunks.
+	CompletionTokens int
+
+	// InterChunk is the distribution of the gaps between consecutive chunks.
+	InterChunk LatencyDistribution
+
+	ChunksPerSecond float64
+	TokensPerSecond float64
+
+	// Err is the error the stream ended with, nil if it finished normally or was closed.
+	Err error
+}
+
+// LatencyDistribution summarizes a set of latencies.
+type LatencyDistribution struct {
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+type streamStatsCollector struct {
+	mu       sync.Mutex
+	stats    ChatCompletionStreamStats
+	last     time.Time
+	gaps     []time.Duration
+	usage    int
+	finished bool
+	hook     func(ChatCompletionStreamStats)
+}
+
+func newStreamStatsCollector(start, headers time.Time, hook func(ChatCompletionStreamStats)) *streamStatsCollector {
+	return &streamStatsCollector{
+		stats: ChatCompletionStreamStats{
+			Start:         start,
+			TimeToHeaders: headers.Sub(start),
+		},
+		last: headers,
+		hook: hook,
+	}
+}
+
+// chunk records a chunk whose bytes were received at the given time.
+func (c *streamStatsCollector) chunk(chunk ChatCompletionStreamResponse, receivedAt time.Time) {
+	now := receivedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats.Chunks == 0 {
+		c.stats.TimeToFirstChunk = now.Sub(c.stats.Start)
+	} else {
+		c.gaps = append(c.gaps, now.Sub(c.last))
+	}
+	c.last = now
+	c.stats.Chunks++
+
+	if hasContent(chunk) {
+		if c.stats.ContentChunks == 0 {
+			c.stats.TimeToFirstContent = now.Sub(c.stats.Start)
+		}
+		c.stats.ContentChunks++
+	}
+	if chunk.Usage.CompletionTokens > 0 {
+		c.usage = chunk.Usage.CompletionTokens
+	}
+}
+
+// finish completes the stats and reports them to the hook. Only the first call has an effect.
+func (c *streamStatsCollector) finish(err error) {
+	c.mu.Lock()
+	if c.finished {
+		c.mu.Unlock()
+		return
+	}
+	c.finished = true
+	c.stats.Err = err
+	c.stats.Duration = time.Since(c.stats.Start)
+	stats := c.snapshot()
+	c.stats = stats
+	c.mu.Unlock()
+
+	if c.hook != nil {
+		c.hook(stats)
+	}
+}
+
+func (c *streamStatsCollector) get() ChatCompletionStreamStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return c.stats
+	}
+
+	stats := c.snapshot()
+	stats.Duration = time.Since(stats.Start)
+	return stats
+}
+
+func (c *streamStatsCollector) snapshot() ChatCompletionStreamStats {
+	stats := c.stats
+	stats.CompletionTokens = stats.ContentChunks
+	if c.usage > 0 {
+		stats.CompletionTokens = c.usage
+	}
+	stats.InterChunk = newLatencyDistribution(c.gaps)
+
+	// throughput is measured from the first chunk on, so that it is not skewed by the time to first chunk
+	if elapsed := (stats.Duration - stats.TimeToFirstChunk).Seconds(); stats.Chunks > 1 && elapsed > 0 {
+		stats.ChunksPerSecond = float64(stats.Chunks-1) / elapsed
+		stats.TokensPerSecond = float64(stats.CompletionTokens) / elapsed
+	}
+	return stats
+}
+
+func newLatencyDistribution(latencies []time.Duration) LatencyDistribution {
+	if len(latencies) == 0 {
+		return LatencyDistribution{}
+	}
+
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return LatencyDistribution{
+		Min:  sorted[0],
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+func hasContent(chunk ChatCompletionStreamResponse) bool {
+	return slices.ContainsFunc(chunk.Choices, func(choice ChatCompletionStreamChoice) bool {
+		delta := choice.Delta
+		return delta.Content != "" || delta.Refusal != "" || delta.FunctionCall != nil || len(delta.ToolCalls) > 0
+	})
+}
@@ -0,0 +1,38 @@
+package openai
+
+// ChatCompletionStreamToken is a content token received on a stream, paired with its
+// log probability.
+type ChatCompletionStreamToken struct {
+	// ChoiceIndex is the index of the choice the token belongs to.
+	ChoiceIndex int
+	LogProb
+}
+
+// OnToken registers fn to be called for every content token with log probabilities as the
+// chunks carrying them are returned by Recv. It requires ChatCompletionRequest.LogProbs to
+// be set, and TopLogProbs to get the alternatives of each token. OnToken must be called
+// before the stream is consumed.
+func (stream *ChatCompletionStream) OnToken(fn func(ChatCompletionStreamToken)) {
+	stream.onToken = append(stream.onToken, fn)
+}
+
+func (stream *ChatCompletionStream) emitTokens(chunk ChatCompletionStreamResponse) {
+	if len(stream.onToken) == 0 {
+		return
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.LogProbs == nil {
+			continue
+		}
+		for _, logProb := range choice.LogProbs.Content {
+			token := ChatCompletionStreamToken{
+				ChoiceIndex: choice.Index,
+				LogProb:     logProb,
+			}
+			for _, fn := range stream.onToken {
+				fn(token)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// ToolCallArgumentEvent reports a member of a tool call's arguments object whose value was
+// streamed completely, before the rest of the arguments are known.
+type ToolCallArgumentEvent struct {
+	ChoiceIndex   int
+	ToolCallIndex int
+	ToolCallID    string
+	FunctionName  string
+
+	// Key is the name of the member and Value its complete JSON encoded value.
+	Key   string
+	Value json.RawMessage
+}
+
+// ToolCallArgumentsParser incrementally decodes the Function.Arguments of streamed tool
+// calls, which arrive as string fragments spread over many chunks.
+type ToolCallArgumentsParser struct {
+	mu    sync.Mutex
+	calls map[toolCallKey]*streamedToolCall
+	last  map[int]int
+}
+
+type toolCallKey struct {
+	choice int
+	index  int
+}
+
+type streamedToolCall struct {
+	id   string
+	name string
+	args partialJSON
+}
+
+func NewToolCallArgumentsParser() *ToolCallArgumentsParser {
+	return &ToolCallArgumentsParser{
+		calls: map[toolCallKey]*streamedToolCall{},
+		last:  map[int]int{},
+	}
+}
+
+// Add feeds the tool call fragments of a chunk to the parser. It returns an event for every
+// argument whose value was completed by the chunk, in the order they were completed.
+func (p *ToolCallArgumentsParser) Add(chunk ChatCompletionStreamResponse) []ToolCallArgumentEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var events []ToolCallArgumentEvent
+	for _, choice := range chunk.Choices {
+		for _, fragment := range choice.Delta.ToolCalls {
+			key := p.key(choice.Index, fragment)
+			call := p.calls[key]
+			if call == nil {
+				call = &streamedToolCall{}
+				p.calls[key] = call
+			}
+			if fragment.ID != "" {
+				call.id = fragment.ID
+			}
+			call.name += fragment.Function.Name
+
+			for _, member := range call.args.Write(fragment.Function.Arguments) {
+				events = append(events, ToolCallArgumentEvent{
+					ChoiceIndex:   key.choice,
+					ToolCallIndex: key.index,
+					ToolCallID:    call.id,
+					FunctionName:  call.name,
+					Key:           member.Key,
+					Value:         member.Value,
+				})
+			}
+		}
+	}
+	return events
+}
+
+// Arguments returns the best-effort decoded arguments of a tool call from the fragments
+// received so far. Incomplete strings are cut off where the stream currently is, and members
+// whose value is not decodable yet are left out. It reports false if nothing can be decoded.
+func (p *ToolCallArgumentsParser) Arguments(choiceIndex, toolCallIndex int) (map[string]any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	call := p.calls[toolCallKey{choice: choiceIndex, index: toolCallIndex}]
+	if call == nil {
+		return nil, false
+	}
+	v, ok := call.args.Value()
+	if !ok {
+		return nil, false
+	}
+	args, ok := v.(map[string]any)
+	return args, ok
+}
+
+func (p *ToolCallArgumentsParser) key(choiceIndex int, fragment ToolCall) toolCallKey {
+	key := toolCallKey{choice: choiceIndex}
+	last, seen := p.last[choiceIndex]
+	switch {
+	case fragment.Index != nil:
+		key.index = *fragment.Index
+	case !seen:
+		key.index = 0
+	default:
+		// Some providers omit the index, in which case a new ID starts a new tool call.
+		key.index = last
+		if call := p.calls[toolCallKey{choice: choiceIndex, index: last}]; fragment.ID != "" && fragment.ID != call.id {
+			key.index++
+		}
+	}
+	p.last[choiceIndex] = key.index
+	return key
+}
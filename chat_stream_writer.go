@@ -0,0 +1,233 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrStreamWriterFinished = errors.New("stream writer has already finished the stream")
+
+// ChatCompletionStreamWriter writes chat completion chunks to an http.ResponseWriter as
+// server-sent events in the format of the OpenAI API. It is the counterpart of
+// ChatCompletionStream for servers re-emitting a stream: a ChatCompletionStream reading its
+// output receives the same chunks and errors that were written.
+type ChatCompletionStreamWriter struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+
+	mu        sync.Mutex
+	started   bool
+	finished  bool
+	lastWrite time.Time
+}
+
+// NewChatCompletionStreamWriter creates a writer for w. The response headers are sent
+// with the first event, so further headers may be set on w until then.
+func NewChatCompletionStreamWriter(w http.ResponseWriter) *ChatCompletionStreamWriter {
+	return &ChatCompletionStreamWriter{
+		w:          w,
+		controller: http.NewResponseController(w),
+	}
+}
+
+// Send writes a chunk as a data event. Fields that are zero are left out, as in the chunks
+// of the OpenAI API.
+func (sw *ChatCompletionStreamWriter) Send(chunk ChatCompletionStreamResponse) error {
+	data, err := marshalEventData(newStreamChunkData(chunk))
+	if err != nil {
+		return err
+	}
+	return sw.sendData(data)
+}
+
+// sendData writes data as a data event, split into one data field per line.
+func (sw *ChatCompletionStreamWriter) sendData(data []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.write(false, "data: %s\n\n", bytes.ReplaceAll(data, []byte("\n"), []byte("\ndata: ")))
+}
+
+// SendError writes an error event in the shape the OpenAI API reports mid-stream errors in,
+// and finishes the stream. An error that is not an *APIError is sent as a server_error with
+// the error's message.
+func (sw *ChatCompletionStreamWriter) SendError(err error) error {
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) {
+		apiErr = &APIError{Message: err.Error(), Type: "server_error"}
+	}
+	data, err := marshalEventData(ErrorResponse{Error: apiErr})
+	if err != nil {
+		return err
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.write(true, "data: %s\n\n", data)
+}
+
+// KeepAlive writes a comment, which clients ignore, to keep idle connections from being
+// closed by proxies.
+func (sw *ChatCompletionStreamWriter) KeepAlive() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.write(false, ": keep-alive\n\n")
+}
+
+func (sw *ChatCompletionStreamWriter) keepAliveIfIdle(idle time.Duration) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if time.Since(sw.lastWrite) < idle {
+		return nil
+	}
+	return sw.write(false, ": keep-alive\n\n")
+}
+
+// Done writes the [DONE] event that terminates the stream and finishes the stream.
+func (sw *ChatCompletionStreamWriter) Done() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.write(true, "data: %s\n\n", doneData)
+}
+
+// Forward writes all chunks received from stream and terminates the output with [DONE],
+// or with an error event if the stream fails. Chunks are written with the data they were
+// received with unless resuming the stream changed them. If keepAlive is greater than zero, a keep-alive
+// comment is written whenever no chunk was written for that long. Forward returns the error
+// the stream failed with or the first write error; it does not close the stream.
+func (sw *ChatCompletionStreamWriter) Forward(stream *ChatCompletionStream, keepAlive time.Duration) error {
+	if keepAlive > 0 {
+		ticker := time.NewTicker(keepAlive)
+		done := make(chan struct{})
+		defer func() {
+			ticker.Stop()
+			close(done)
+		}()
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if sw.keepAliveIfIdle(keepAlive) != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return sw.Done()
+		}
+		if err != nil {
+			_ = sw.SendError(err)
+			return err
+		}
+		if stream.raw != nil {
+			err = sw.sendData(stream.raw)
+		} else {
+			err = sw.Send(chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sw *ChatCompletionStreamWriter) writeHeader() {
+	if sw.started {
+		return
+	}
+	sw.started = true
+
+	header := sw.w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	sw.w.WriteHeader(http.StatusOK)
+}
+
+func (sw *ChatCompletionStreamWriter) write(finish bool, format string, args ...any) error {
+	if sw.finished {
+		return ErrStreamWriterFinished
+	}
+	sw.finished = finish
+	sw.lastWrite = time.Now()
+	sw.writeHeader()
+
+	if _, err := fmt.Fprintf(sw.w, format, args...); err != nil {
+		return err
+	}
+	if err := sw.controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// marshalEventData encodes v without escaping HTML characters, as the OpenAI API does.
+func marshalEventData(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// streamChunkData is the encoding of a ChatCompletionStreamResponse that leaves out the fields
+// that are zero, which omitempty does not do for structs.
+type streamChunkData struct {
+	ID                string             `json:"id"`
+	Object            string             `json:"object,omitempty"`
+	Created           int64              `json:"created,omitempty"`
+	Model             string             `json:"model,omitempty"`
+	Choices           []streamChoiceData `json:"choices"`
+	PromptAnnotations []PromptAnnotation `json:"prompt_annotations,omitempty"`
+	Usage             *Usage             `json:"usage,omitempty"`
+	SystemFingerprint string             `json:"system_fingerprint,omitempty"`
+}
+
+type streamChoiceData struct {
+	Index                int                             `json:"index"`
+	Delta                ChatCompletionStreamChoiceDelta `json:"delta"`
+	FinishReason         FinishReason                    `json:"finish_reason"`
+	ContentFilterResults *ContentFilterResults           `json:"content_filter_results,omitempty"`
+	LogProbs             *LogProbs                       `json:"logprobs,omitempty"`
+}
+
+func newStreamChunkData(chunk ChatCompletionStreamResponse) streamChunkData {
+	data := streamChunkData{
+		ID:                chunk.ID,
+		Object:            chunk.Object,
+		Created:           chunk.Created,
+		Model:             chunk.Model,
+		Choices:           make([]streamChoiceData, 0, len(chunk.Choices)),
+		PromptAnnotations: chunk.PromptAnnotations,
+		SystemFingerprint: chunk.SystemFingerprint,
+	}
+	if chunk.Usage != (Usage{}) {
+		data.Usage = &chunk.Usage
+	}
+	for _, choice := range chunk.Choices {
+		choiceData := streamChoiceData{
+			Index:        choice.Index,
+			Delta:        choice.Delta,
+			FinishReason: choice.FinishReason,
+			LogProbs:     choice.LogProbs,
+		}
+		if choice.ContentFilterResults != (ContentFilterResults{}) {
+			choiceData.ContentFilterResults = &choice.ContentFilterResults
+		}
+		data.Choices = append(data.Choices, choiceData)
+	}
+	return data
+}
@@ -0,0 +1,92 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// upstreamStream is a chat completion stream as sent by the OpenAI API, including fields the
+// client does not decode and explicit nulls.
+const upstreamStream = `data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1715594400,"model":"gpt-4o-2024-05-13","service_tier":"default","system_fingerprint":"fp_44709d6fcb","choices":[{"index":0,"delta":{"role":"assistant","content":"","refusal":null},"logprobs":null,"finish_reason":null}],"usage":null}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1715594400,"model":"gpt-4o-2024-05-13","service_tier":"default","system_fingerprint":"fp_44709d6fcb","choices":[{"index":0,"delta":{"content":"Hello <world> & you"},"logprobs":null,"finish_reason":null}],"usage":null}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1715594400,"model":"gpt-4o-2024-05-13","service_tier":"default","system_fingerprint":"fp_44709d6fcb","choices":[{"index":0,"delta":{},"logprobs":null,"finish_reason":"stop"}],"usage":null}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1715594400,"model":"gpt-4o-2024-05-13","service_tier":"default","system_fingerprint":"fp_44709d6fcb","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":5,"total_tokens":14}}
+
+data: [DONE]
+
+`
+
+func TestChatCompletionStreamWriterForwardsUpstreamBytes(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, upstreamStream)
+	}), nil)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := client.CreateChatCompletionStream(r.Context(), ChatCompletionRequest{Model: GPT4o}, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer stream.Close()
+		if err := NewChatCompletionStreamWriter(w).Forward(stream, time.Minute); err != nil {
+			t.Errorf("Forward: %v", err)
+		}
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	forwarded, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(forwarded) != upstreamStream {
+		t.Errorf("forwarded stream differs from upstream:\n got: %s\nwant: %s", forwarded, upstreamStream)
+	}
+}
+
+func TestChatCompletionStreamWriterSendOmitsZeroFields(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := NewChatCompletionStreamWriter(recorder)
+	err := writer.Send(ChatCompletionStreamResponse{
+		ID:      "chatcmpl-123",
+		Choices: []ChatCompletionStreamChoice{{Delta: ChatCompletionStreamChoiceDelta{Content: "<b>"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `data: {"id":"chatcmpl-123","choices":[{"index":0,"delta":{"content":"<b>"},"finish_reason":null}]}
+
+data: [DONE]
+
+`
+	if got := recorder.Body.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	reader := newStreamReader[ChatCompletionStreamResponse](&http.Response{
+		Body: io.NopCloser(recorder.Body),
+	}, defaultEmptyMessagesLimit)
+	defer reader.Close()
+	chunk, err := reader.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.ID != "chatcmpl-123" || chunk.Choices[0].Delta.Content != "<b>" {
+		t.Errorf("unexpected chunk %+v", chunk)
+	}
+}
@@ -0,0 +1,346 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CircuitState string
+
+const (
+	// CircuitClosed lets all requests through while the failures are counted.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen fails all requests fast until the cool-down has passed.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a limited number of probe requests through. The circuit closes
+	// when they succeed and opens again when one of them fails.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned for requests rejected by an open circuit. It matches ErrCircuitOpen.
+type CircuitOpenError struct {
+	URL   string
+	Model string
+	// RetryAt is the time the circuit lets probe requests through again.
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v for %s (model %q), retry at %s", ErrCircuitOpen, e.URL, e.Model, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitStateChange describes a transition of the circuit of a target.
+type CircuitStateChange struct {
+	URL   string
+	Model string
+	From  CircuitState
+	To    CircuitState
+	// FailureRate is the failure rate in the window at the time of the transition.
+	FailureRate float64
+}
+
+// CircuitBreakerConfig configures a CircuitBreaker. Zero values are replaced by the defaults.
+type CircuitBreakerConfig struct {
+	// FailureRateThreshold is the rate of failed requests within Window at which the circuit
+	// opens. Defaults to 0.5.
+	FailureRateThreshold float64
+	// MinRequests is the number of requests within Window needed before the failure rate is
+	// considered. Defaults to 10.
+	MinRequests int
+	// Window is the time over which the failure rate is measured. Defaults to one minute.
+	Window time.Duration
+	// CoolDown is the time an open circuit rejects requests before it lets probe requests
+	// through. Defaults to 30 seconds.
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of probe requests that must succeed to close the
+	// circuit again. Defaults to 1.
+	HalfOpenRequests int
+
+	// OnStateChange is called whenever the circuit of a target changes its state.
+	OnStateChange func(CircuitStateChange)
+}
+
+// CircuitBreaker fails requests fast while their target keeps failing, see
+// ClientConfig.CircuitBreaker. Every target, that is the base URL the request is sent to,
+// including the deployment for Azure, together with the model of the request, has its own
+// circuit. Transport errors and 5xx responses count as failures. Closed circuits that have
+// not been used for Window and have no request in flight are dropped.
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	circuits map[circuitKey]*circuit
+	// sweptAt is the time idle circuits were last dropped.
+	sweptAt time.Time
+}
+
+type circuitKey struct {
+	url   string
+	model string
+}
+
+type circuit struct {
+	state    CircuitState
+	outcomes []circuitOutcome
+	openedAt time.Time
+	usedAt   time.Time
+	// inFlight is the number of admitted requests whose outcome is not recorded yet.
+	inFlight int
+	// probes is the number of probe requests in flight and successes the number of
+	// successful ones while half-open.
+	probes    int
+	successes int
+}
+
+type circuitOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureRateThreshold <= 0 {
+		config.FailureRateThreshold = 0.5
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 10
+	}
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = 30 * time.Second
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{
+		config:   config,
+		circuits: map[circuitKey]*circuit{},
+	}
+}
+
+// State returns the state of the circuit of a target, given by a base URL without a trailing
+// slash, followed by "/openai/deployments/{deployment}" for Azure, and the model.
+func (b *CircuitBreaker) State(url, model string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[circuitKey{url: url, model: model}]
+	if c == nil {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.config.CoolDown {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// allow admits a request to the target of req, or returns a *CircuitOpenError. baseURLs are
+// the base URLs the client sends requests to. The returned function must be called with the
+// outcome of an admitted request.
+func (b *CircuitBreaker) allow(req *http.Request, body []byte, baseURLs []string) (func(*http.Response, error), error) {
+	key := newCircuitKey(req.URL, body, baseURLs)
+	now := time.Now()
+
+	b.mu.Lock()
+	b.sweep(now)
+	c := b.circuits[key]
+	if c == nil {
+		c = &circuit{state: CircuitClosed}
+		b.circuits[key] = c
+	}
+	c.usedAt = now
+
+	var changes []CircuitStateChange
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.config.CoolDown {
+		changes = append(changes, b.transition(key, c, CircuitHalfOpen))
+	}
+
+	var probe bool
+	switch c.state {
+	case CircuitOpen:
+		err := &CircuitOpenError{URL: key.url, Model: key.model, RetryAt: c.openedAt.Add(b.config.CoolDown)}
+		b.mu.Unlock()
+		b.notify(changes)
+		return nil, err
+	case CircuitHalfOpen:
+		if c.probes+c.successes >= b.config.HalfOpenRequests {
+			err := &CircuitOpenError{URL: key.url, Model: key.model, RetryAt: time.Now()}
+			b.mu.Unlock()
+			b.notify(changes)
+			return nil, err
+		}
+		c.probes++
+		probe = true
+	}
+	c.inFlight++
+	b.mu.Unlock()
+	b.notify(changes)
+
+	return func(resp *http.Response, err error) {
+		b.record(key, c, probe, resp, err)
+	}, nil
+}
+
+func (b *CircuitBreaker) record(key circuitKey, c *circuit, probe bool, resp *http.Response, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// says nothing about the target
+		b.mu.Lock()
+		c.inFlight--
+		if probe && c.state == CircuitHalfOpen {
+			c.probes--
+		}
+		b.mu.Unlock()
+		return
+	}
+	failed := err != nil || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+	now := time.Now()
+
+	b.mu.Lock()
+	c.inFlight--
+	c.usedAt = now
+	var changes []CircuitStateChange
+	switch {
+	case probe && c.state == CircuitHalfOpen:
+		c.probes--
+		if failed {
+			changes = append(changes, b.transition(key, c, CircuitOpen))
+			break
+		}
+		c.successes++
+		if c.successes >= b.config.HalfOpenRequests {
+			changes = append(changes, b.transition(key, c, CircuitClosed))
+		}
+	case c.state == CircuitClosed:
+		c.outcomes = append(c.outcomes, circuitOutcome{at: now, failed: failed})
+		c.prune(now.Add(-b.config.Window))
+		if failed && len(c.outcomes) >= b.config.MinRequests && c.failureRate() >= b.config.FailureRateThreshold {
+			changes = append(changes, b.transition(key, c, CircuitOpen))
+		}
+	}
+	b.mu.Unlock()
+	b.notify(changes)
+}
+
+// transition changes the state of a circuit. It must be called with b.mu held.
+func (b *CircuitBreaker) transition(key circuitKey, c *circuit, to CircuitState) CircuitStateChange {
+	change := CircuitStateChange{
+		URL:         key.url,
+		Model:       key.model,
+		From:        c.state,
+		To:          to,
+		FailureRate: c.failureRate(),
+	}
+
+	c.state = to
+	c.probes, c.successes = 0, 0
+	switch to {
+	case CircuitOpen:
+		c.openedAt = time.Now()
+	case CircuitClosed:
+		c.outcomes = nil
+	}
+	return change
+}
+
+// sweep drops the closed circuits that have not been used for Window, at most once per
+// Window. Circuits with requests in flight are kept, so that their outcome is not recorded
+// on a circuit that is no longer in the map. It must be called with b.mu held.
+func (b *CircuitBreaker) sweep(now time.Time) {
+	if now.Sub(b.sweptAt) < b.config.Window {
+		return
+	}
+	b.sweptAt = now
+	for key, c := range b.circuits {
+		if c.state == CircuitClosed && c.inFlight == 0 && now.Sub(c.usedAt) >= b.config.Window {
+			delete(b.circuits, key)
+		}
+	}
+}
+
+func (b *CircuitBreaker) notify(changes []CircuitStateChange) {
+	if b.config.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.config.OnStateChange(change)
+	}
+}
+
+func (c *circuit) prune(since time.Time) {
+	i := 0
+	for i < len(c.outcomes) && c.outcomes[i].at.Before(since) {
+		i++
+	}
+	c.outcomes = c.outcomes[i:]
+}
+
+func (c *circuit) failureRate() float64 {
+	if len(c.outcomes) == 0 {
+		return 0
+	}
+	var failures int
+	for _, outcome := range c.outcomes {
+		if outcome.failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(c.outcomes))
+}
+
+// newCircuitKey returns the key of the target of a request to u: the longest of baseURLs the
+// request is sent to, or the scheme and host if it is sent to none of them, followed by the
+// Azure deployment, if any. The rest of the path is left out, so that requests for threads,
+// runs and other resources share the circuit of their target.
+func newCircuitKey(u *url.URL, body []byte, baseURLs []string) circuitKey {
+	target := u.Scheme + "://" + u.Host
+	endpoint := *u
+	endpoint.RawQuery = ""
+	endpoint.Fragment = ""
+	rawURL := endpoint.String()
+	for _, baseURL := range baseURLs {
+		baseURL = strings.TrimRight(baseURL, "/")
+		if len(baseURL) > len(target) && strings.HasPrefix(rawURL, baseURL) {
+			target = baseURL
+		}
+	}
+
+	deployments := "/" + azureAPIPrefix + "/" + azureDeploymentsPrefix + "/"
+	if path, ok := strings.CutPrefix(strings.TrimPrefix(rawURL, target), deployments); ok {
+		deployment, _, _ := strings.Cut(path, "/")
+		target += deployments + deployment
+	}
+
+	var request struct {
+		Model string `json:"model"`
+	}
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &request)
+	}
+	return circuitKey{url: target, model: request.Model}
+}
+
+// circuitBaseURLs returns the base URLs the client sends requests to.
+func (c *Client) circuitBaseURLs() []string {
+	baseURLs := []string{c.config.BaseURL}
+	if hedger := c.config.Hedger; hedger != nil {
+		for _, target := range hedger.config.Targets {
+			if target.BaseURL != "" {
+				baseURLs = append(baseURLs, target.BaseURL)
+			}
+		}
+	}
+	return baseURLs
+}
@@ -0,0 +1,227 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server := failingServer(http.StatusInternalServerError, &failing)
+	var (
+		mu      sync.Mutex
+		changes []CircuitState
+	)
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		MinRequests:      2,
+		CoolDown:         50 * time.Millisecond,
+		HalfOpenRequests: 2,
+		OnStateChange: func(change CircuitStateChange) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, change.To)
+		},
+	})
+	config := newTestConfig(t, server)
+	config.CircuitBreaker = breaker
+	client := NewClientWithConfig(config)
+	send := func() error {
+		_, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+		return err
+	}
+
+	for range 2 {
+		if err := send(); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("got error %v, want the error of the server", err)
+		}
+	}
+	if got := breaker.State(config.BaseURL, GPT4o); got != CircuitOpen {
+		t.Fatalf("got state %s, want open", got)
+	}
+	// an open circuit fails fast
+	var openErr *CircuitOpenError
+	if err := send(); !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v, want a CircuitOpenError", err)
+	}
+	if got := len(server.requests()); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+	// other models have their own circuit
+	if _, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4}, nil); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got error %v for another model", err)
+	}
+
+	// a failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	if got := breaker.State(config.BaseURL, GPT4o); got != CircuitHalfOpen {
+		t.Fatalf("got state %s after the cool-down, want half-open", got)
+	}
+	if err := send(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v, want the error of the server", err)
+	}
+	if err := send(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// the circuit closes once HalfOpenRequests probes succeeded
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for range 2 {
+		if err := send(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := breaker.State(config.BaseURL, GPT4o); got != CircuitClosed {
+		t.Errorf("got state %s, want closed", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("got state changes %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("got state changes %v, want %v", changes, want)
+			break
+		}
+	}
+}
+
+func newCircuitRequest(t *testing.T, rawURL string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestCircuitBreakerLimitsProbes(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, CoolDown: 20 * time.Millisecond})
+	req := newCircuitRequest(t, "https://api.example.com/v1/chat/completions")
+	body := []byte(`{"model":"gpt-4o"}`)
+	baseURLs := []string{"https://api.example.com/v1"}
+
+	done, err := breaker.allow(req, body, baseURLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(nil, errors.New("connection refused"))
+	if _, err := breaker.allow(req, body, baseURLs); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v, want %v", err, ErrCircuitOpen)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, err := breaker.allow(req, body, baseURLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only one probe is let through at once
+	if _, err := breaker.allow(req, body, baseURLs); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v while a probe is in flight, want %v", err, ErrCircuitOpen)
+	}
+	// a canceled probe says nothing about the target and frees its place
+	probe(nil, context.Canceled)
+	probe, err = breaker.allow(req, body, baseURLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe(&http.Response{StatusCode: http.StatusOK}, nil)
+	if got := breaker.State("https://api.example.com/v1", GPT4o); got != CircuitClosed {
+		t.Errorf("got state %s, want closed", got)
+	}
+}
+
+func TestCircuitBreakerKeepsCircuitsInUse(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, Window: 10 * time.Millisecond})
+	body := []byte(`{"model":"gpt-4o"}`)
+	done, err := breaker.allow(newCircuitRequest(t, "https://a.example.com/v1/chat/completions"), body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the circuit of the request in flight is not dropped by a sweep, so its failure counts
+	time.Sleep(20 * time.Millisecond)
+	if _, err := breaker.allow(newCircuitRequest(t, "https://b.example.com/v1/chat/completions"), body, nil); err != nil {
+		t.Fatal(err)
+	}
+	done(&http.Response{StatusCode: http.StatusBadGateway}, nil)
+	if got := breaker.State("https://a.example.com", GPT4o); got != CircuitOpen {
+		t.Errorf("got state %s, want open", got)
+	}
+
+	// idle closed circuits are dropped
+	time.Sleep(20 * time.Millisecond)
+	if _, err := breaker.allow(newCircuitRequest(t, "https://b.example.com/v1/chat/completions"), body, nil); err != nil {
+		t.Fatal(err)
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if len(breaker.circuits) != 2 {
+		t.Errorf("got %d circuits, want the open one and the one in use", len(breaker.circuits))
+	}
+}
+
+func TestNewCircuitKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		baseURLs []string
+		want     circuitKey
+	}{
+		{
+			name:     "base URL and model",
+			url:      "https://api.example.com/v1/chat/completions?x=1",
+			body:     `{"model":"gpt-4o"}`,
+			baseURLs: []string{"https://api.example.com/v1/"},
+			want:     circuitKey{url: "https://api.example.com/v1", model: "gpt-4o"},
+		},
+		{
+			name:     "longest base URL",
+			url:      "https://api.example.com/proxy/v1/chat/completions",
+			baseURLs: []string{"https://api.example.com", "https://api.example.com/proxy/v1"},
+			want:     circuitKey{url: "https://api.example.com/proxy/v1"},
+		},
+		{
+			name: "no matching base URL",
+			url:  "https://other.example.com/v1/threads/1/runs",
+			body: `{"model":"gpt-4o"}`,
+			want: circuitKey{url: "https://other.example.com", model: "gpt-4o"},
+		},
+		{
+			name:     "Azure deployment",
+			url:      "https://x.openai.azure.com/openai/deployments/gpt4o-east/chat/completions?api-version=2024-06-01",
+			body:     `{"model":"gpt-4o"}`,
+			baseURLs: []string{"https://x.openai.azure.com"},
+			want:     circuitKey{url: "https://x.openai.azure.com/openai/deployments/gpt4o-east", model: "gpt-4o"},
+		},
+		{
+			name:     "body that is not JSON",
+			url:      "https://api.example.com/v1/audio/transcriptions",
+			body:     "--boundary",
+			baseURLs: []string{"https://api.example.com/v1"},
+			want:     circuitKey{url: "https://api.example.com/v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := newCircuitKey(u, []byte(tt.body), tt.baseURLs); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -9,36 +8,53 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"math/rand"
 	"net/http"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Client is OpenAI GPT-3 API client.
 type Client struct {
-	config ClientConfig
+	config      ClientConfig
+	credentials *credentialCache
+
+	// mu guards config.authToken, which SetAPIKey may change while requests are made.
+	mu sync.RWMutex
 }
 
 type Response interface {
 	SetHeader(http.Header)
 }
 
-type httpHeader http.Header
+type httpHeader struct {
+	header   http.Header
+	apiKeyID string
+}
 
 func (h *httpHeader) SetHeader(header http.Header) {
-	*h = httpHeader(header)
+	h.header = header
 }
 
 func (h *httpHeader) Header() http.Header {
-	return http.Header(*h)
+	return h.header
 }
 
 func (h *httpHeader) GetRateLimitHeaders() RateLimitHeaders {
 	return newRateLimitHeaders(h.Header())
 }
 
+// APIKeyID returns the ID of the pooled API key the response was requested with, see
+// ClientConfig.APIKeyPool.
+func (h *httpHeader) APIKeyID() string {
+	return h.apiKeyID
+}
+
+func (h *httpHeader) setAPIKeyID(id string) {
+	h.apiKeyID = id
+}
+
 // NewClient creates new OpenAI API client.
 func NewClient(authToken string) *Client {
 	config := DefaultConfig(authToken)
@@ -47,16 +63,26 @@ func NewClient(authToken string) *Client {
 
 // NewClientWithConfig creates new OpenAI API client for specified config.
 func NewClientWithConfig(config ClientConfig) *Client {
-	return &Client{
+	c := &Client{
 		config: config,
 	}
+	if config.CredentialProvider != nil {
+		c.credentials = newCredentialCache(config.CredentialProvider)
+	}
+	return c
 }
 
 func (c *Client) GetAPIKeyAndBaseURL() (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.config.authToken, c.config.BaseURL
 }
 
+// SetAPIKey replaces the API key of the client. It is safe to call while requests are made.
+// The keys of ClientConfig.APIKeyPool and ClientConfig.CredentialProvider take precedence over it.
 func (c *Client) SetAPIKey(apiKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.config.authToken = apiKey
 }
 
@@ -97,6 +123,37 @@ type RetryOptions struct {
 	// RetryAboveCode is the status code above which the request should be retried.
 	RetryAboveCode int
 	RetryCodes     []int
+	// RetryPredicate decides which failures are retried instead of RetryAboveCode and
+	// RetryCodes. See DefaultRetryPredicate.
+	RetryPredicate RetryPredicate
+
+	// StreamResumes is the number of times a chat completion stream that breaks
+	// mid-way is transparently re-established. 0 means streams are not resumed.
+	// A request without a Seed is sent with a random one, so that a restarted
+	// generation is as close as possible to the broken one. Set the Seed of the
+	// request to choose it instead.
+	StreamResumes int
+	// StreamResumeStrategy selects how a broken stream is re-established.
+	// Defaults to StreamResumeStrategyRestart.
+	StreamResumeStrategy StreamResumeStrategy
+
+	// FirstChunkTimeout overrides ClientConfig.StreamFirstChunkTimeout.
+	FirstChunkTimeout time.Duration
+	// ChunkIdleTimeout overrides ClientConfig.StreamChunkIdleTimeout.
+	ChunkIdleTimeout time.Duration
+	// RetryStreamTimeouts retries a stream whose first chunk timed out, the same as a
+	// retriable status code. Idle timeouts later on are handled by StreamResumes.
+	RetryStreamTimeouts bool
+
+	// Backoff computes the delay before a retry unless the server requests one through the
+	// Retry-After, retry-after-ms or x-ratelimit-reset-* headers. Defaults to 200ms·2^attempt
+	// plus up to 200ms of jitter.
+	Backoff Backoff
+	// MaxRetryDelay caps the delay before a retry. Defaults to one minute.
+	MaxRetryDelay time.Duration
+	// MaxRetryElapsed stops retrying once the next retry would start later than this after
+	// the first attempt. 0 means no limit.
+	MaxRetryElapsed time.Duration
 }
 
 func NewDefaultRetryOptions() RetryOptions {
@@ -115,6 +172,33 @@ func (r *RetryOptions) complete(opts ...RetryOptions) {
 		if opt.RetryAboveCode > 0 {
 			r.RetryAboveCode = opt.RetryAboveCode
 		}
+		if opt.RetryPredicate != nil {
+			r.RetryPredicate = opt.RetryPredicate
+		}
+		if opt.StreamResumes > 0 {
+			r.StreamResumes = opt.StreamResumes
+		}
+		if opt.StreamResumeStrategy != "" {
+			r.StreamResumeStrategy = opt.StreamResumeStrategy
+		}
+		if opt.FirstChunkTimeout > 0 {
+			r.FirstChunkTimeout = opt.FirstChunkTimeout
+		}
+		if opt.ChunkIdleTimeout > 0 {
+			r.ChunkIdleTimeout = opt.ChunkIdleTimeout
+		}
+		if opt.RetryStreamTimeouts {
+			r.RetryStreamTimeouts = true
+		}
+		if opt.Backoff != nil {
+			r.Backoff = opt.Backoff
+		}
+		if opt.MaxRetryDelay > 0 {
+			r.MaxRetryDelay = opt.MaxRetryDelay
+		}
+		if opt.MaxRetryElapsed > 0 {
+			r.MaxRetryElapsed = opt.MaxRetryElapsed
+		}
 		for _, code := range opt.RetryCodes {
 			if !slices.Contains(r.RetryCodes, code) {
 				r.RetryCodes = append(r.RetryCodes, code)
@@ -144,11 +228,12 @@ func (c *Client) sendRequest(req *http.Request, v Response, retryOpts ...RetryOp
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	const baseDelay = time.Millisecond * 200
 	var (
 		resp     *http.Response
 		err      error
 		failures []string
+		attempts []RetryAttempt
+		delay    time.Duration
 	)
 
 	// Save the original request body
@@ -161,17 +246,22 @@ func (c *Client) sendRequest(req *http.Request, v Response, retryOpts ...RetryOp
 		}
 	}
 
+	start := time.Now()
 	for i := 0; i <= options.Retries; i++ {
 		// Reset body to the original request body
 		if bodyBytes != nil {
 			req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 		}
 
-		resp, err = c.config.HTTPClient.Do(req)
+		attemptStart := time.Now()
+		resp, err = c.doHTTP(req, bodyBytes)
 		if err == nil && !isFailureStatusCode(resp) {
 			defer resp.Body.Close()
 			if v != nil {
 				v.SetHeader(resp.Header)
+				if v, ok := v.(interface{ setAPIKeyID(string) }); ok {
+					v.setAPIKeyID(apiKeyIDOf(resp))
+				}
 			}
 			return decodeResponse(resp.Body, v)
 		}
@@ -182,28 +272,46 @@ func (c *Client) sendRequest(req *http.Request, v Response, retryOpts ...RetryOp
 		}
 
 		// handle status codes
-		failures = append(failures, fmt.Sprintf("#%d/%d error response received: %v", i+1, options.Retries+1, c.handleErrorResp(resp)))
+		errResp := c.handleErrorResp(resp)
+		if resp != nil {
+			failures = append(failures, fmt.Sprintf("#%d/%d error response received: %v", i+1, options.Retries+1, errResp))
+		}
+		attempts = append(attempts, newRetryAttempt(resp, errResp, err, delay, time.Since(attemptStart)))
 
-		// exit on non-retriable status codes
-		if resp != nil && !options.canRetry(resp.StatusCode) {
+		// exit on non-retriable errors
+		if !options.shouldRetry(i, resp, errResp, err) {
+			if resp == nil {
+				slog.Error("sendRequest failed due to non-retriable error", "err", err, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+				return &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request failed on non-retriable error: %v", err)}
+			}
 			failures = append(failures, fmt.Sprintf("exiting due to non-retriable error in try #%d/%d: %d %s", i+1, options.Retries+1, resp.StatusCode, resp.Status))
 			slog.Error("sendRequest failed due to non-retriable statuscode", "code", resp.StatusCode, "status", resp.Status, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
-			return fmt.Errorf("request failed on non-retriable status-code: %d %s", resp.StatusCode, resp.Status)
+			return &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request failed on non-retriable status-code: %d %s", resp.StatusCode, resp.Status)}
+		}
+
+		if i == options.Retries {
+			break
 		}
 
-		// exponential backoff
-		delay := baseDelay * time.Duration(1<<i)
-		jitter := time.Duration(rand.Int63n(int64(baseDelay)))
+		delay = options.retryDelay(i, delay, resp)
+		if options.exceedsElapsed(start, delay) {
+			slog.Error("sendRequest failed after exceeding the maximum retry time", "maxElapsed", options.MaxRetryElapsed, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+			return &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request exceeded retry time limit of %s: %s", options.MaxRetryElapsed, failures[len(failures)-1])}
+		}
+		if exceedsDeadline(req.Context(), delay) {
+			slog.Error("sendRequest failed because the retry delay exceeds the context deadline", "delay", delay, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+			return &RetryExhaustedError{Attempts: attempts, Err: ErrRetryAfterDeadline, msg: fmt.Sprintf("%v (%s): %s", ErrRetryAfterDeadline, delay, failures[len(failures)-1])}
+		}
 		select {
 		case <-req.Context().Done():
 			slog.Error("sendRequest failed due to canceled context", "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
-			return fmt.Errorf("request failed due to canceled context: %w", req.Context().Err())
-		case <-time.After(delay + jitter):
+			return &RetryExhaustedError{Attempts: attempts, Err: req.Context().Err(), msg: fmt.Sprintf("request failed due to canceled context: %v", req.Context().Err())}
+		case <-time.After(delay):
 		}
 	}
 
 	slog.Error("sendRequest failed after exceeding retry limit", "tries", options.Retries+1, "failures", strings.Join(failures, "; "))
-	return fmt.Errorf("request exceeded retry limits: %s", failures[len(failures)-1])
+	return &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request exceeded retry limits: %s", failures[len(failures)-1])}
 }
 
 func sendRequestStream[T streamable](client *Client, req *http.Request, retryOpts ...RetryOptions) (*streamReader[T], error) {
@@ -216,10 +324,21 @@ func sendRequestStream[T streamable](client *Client, req *http.Request, retryOpt
 	options := NewDefaultRetryOptions()
 	options.complete(retryOpts...)
 
-	const baseDelay = time.Millisecond * 200
+	firstChunkTimeout := client.config.StreamFirstChunkTimeout
+	if options.FirstChunkTimeout > 0 {
+		firstChunkTimeout = options.FirstChunkTimeout
+	}
+	chunkIdleTimeout := client.config.StreamChunkIdleTimeout
+	if options.ChunkIdleTimeout > 0 {
+		chunkIdleTimeout = options.ChunkIdleTimeout
+	}
+
 	var (
-		err      error
-		failures []string
+		err        error
+		failures   []string
+		attempts   []RetryAttempt
+		timeoutErr error
+		delay      time.Duration
 	)
 
 	// Save the original request body
@@ -232,66 +351,146 @@ func sendRequestStream[T streamable](client *Client, req *http.Request, retryOpt
 		}
 	}
 
+	start := time.Now()
 	for i := 0; i <= options.Retries; i++ {
 		// Reset body to the original request body
 		if bodyBytes != nil {
 			req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 		}
 
-		resp, err := client.config.HTTPClient.Do(req) //nolint:bodyclose // body is closed in stream.Close()
+		sentAt := time.Now()
+		resp, err := client.doHTTP(req, bodyBytes) //nolint:bodyclose // body is closed in stream.Close()
 		if err == nil && !isFailureStatusCode(resp) {
-			// we're good!
-			return &streamReader[T]{
-				emptyMessagesLimit: client.config.EmptyMessagesLimit,
-				reader:             bufio.NewReader(resp.Body),
-				response:           resp,
-				errBuffer:          &bytes.Buffer{},
-				httpHeader:         httpHeader(resp.Header),
-			}, nil
-		}
+			stream := newStreamReader[T](resp, client.config.EmptyMessagesLimit)
+			stream.sentAt, stream.headersAt = sentAt, time.Now()
+			stream.watchdog = newStreamWatchdog(resp.Body, firstChunkTimeout, chunkIdleTimeout)
+			if !options.RetryStreamTimeouts || firstChunkTimeout <= 0 || !errors.Is(stream.peek(), ErrStreamTimeout) {
+				// we're good!
+				return stream, nil
+			}
 
-		if err != nil {
-			failures = append(failures, fmt.Sprintf("#%d/%d failed to send request: %v", i+1, options.Retries+1, err))
-		}
+			// the first chunk timed out, which is safe to retry since nothing was received yet
+			_ = stream.Close()
+			timeoutErr = stream.peeked.err
+			failures = append(failures, fmt.Sprintf("#%d/%d %v", i+1, options.Retries+1, timeoutErr))
+			attempts = append(attempts, newRetryAttempt(resp, nil, timeoutErr, delay, time.Since(sentAt)))
+		} else {
+			timeoutErr = nil
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("#%d/%d failed to send request: %v", i+1, options.Retries+1, err))
+			}
 
-		// handle status codes
-		errResp := client.handleErrorResp(resp)
-		failures = append(failures, fmt.Sprintf("#%d/%d error response received: %v", i+1, options.Retries+1, errResp))
+			// handle status codes
+			errResp := client.handleErrorResp(resp)
+			if resp != nil {
+				failures = append(failures, fmt.Sprintf("#%d/%d error response received: %v", i+1, options.Retries+1, errResp))
+			}
+			attempts = append(attempts, newRetryAttempt(resp, errResp, err, delay, time.Since(sentAt)))
+
+			// exit on non-retriable errors
+			if !options.shouldRetry(i, resp, errResp, err) {
+				if resp == nil {
+					slog.Error("sendRequestStream failed due to non-retriable error", "err", err, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+					return nil, &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request failed on non-retriable error: %v", err)}
+				}
+				failures = append(failures, fmt.Sprintf("exiting due to non-retriable error in try #%d/%d: %d %s", i+1, options.Retries+1, resp.StatusCode, resp.Status))
+				slog.Error("sendRequestStream failed due to non-retriable statuscode", "code", resp.StatusCode, "status", resp.Status, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+				return nil, &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request failed on non-retriable status-code %d: %s", resp.StatusCode, errResp.Error())}
+			}
+		}
 
-		// exit on non-retriable status codes
-		if resp != nil && !options.canRetry(resp.StatusCode) {
-			failures = append(failures, fmt.Sprintf("exiting due to non-retriable error in try #%d/%d: %d %s", i+1, options.Retries+1, resp.StatusCode, resp.Status))
-			slog.Error("sendRequestStream failed due to non-retriable statuscode", "code", resp.StatusCode, "status", resp.Status, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
-			return nil, fmt.Errorf("request failed on non-retriable status-code %d: %s", resp.StatusCode, errResp.Error())
+		if i == options.Retries {
+			break
 		}
 
-		// exponential backoff
-		delay := baseDelay * time.Duration(1<<i)
-		jitter := time.Duration(rand.Int63n(int64(baseDelay)))
+		delay = options.retryDelay(i, delay, resp)
+		if options.exceedsElapsed(start, delay) {
+			slog.Error("sendRequestStream failed after exceeding the maximum retry time", "maxElapsed", options.MaxRetryElapsed, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+			return nil, &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request exceeded retry time limit of %s: %s", options.MaxRetryElapsed, failures[len(failures)-1])}
+		}
+		if exceedsDeadline(req.Context(), delay) {
+			slog.Error("sendRequestStream failed because the retry delay exceeds the context deadline", "delay", delay, "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
+			return nil, &RetryExhaustedError{Attempts: attempts, Err: ErrRetryAfterDeadline, msg: fmt.Sprintf("%v (%s): %s", ErrRetryAfterDeadline, delay, failures[len(failures)-1])}
+		}
 		select {
 		case <-req.Context().Done():
 			failures = append(failures, fmt.Sprintf("exiting due to canceled context after try #%d/%d: %v", i+1, options.Retries+1, req.Context().Err()))
 			slog.Error("sendRequestStream failed due to canceled context", "tries", i+1, "maxTries", options.Retries+1, "failures", strings.Join(failures, "; "))
-			return nil, fmt.Errorf("request failed due to canceled context: %w", req.Context().Err())
-		case <-time.After(delay + jitter):
+			return nil, &RetryExhaustedError{Attempts: attempts, Err: req.Context().Err(), msg: fmt.Sprintf("request failed due to canceled context: %v", req.Context().Err())}
+		case <-time.After(delay):
 		}
 	}
 
 	slog.Error("sendRequestStream failed after exceeding retry limit", "tries", options.Retries+1, "failures", strings.Join(failures, "; "))
-	return nil, fmt.Errorf("request exceeded retry limits: %s", failures[len(failures)-1])
+	return nil, &RetryExhaustedError{Attempts: attempts, msg: fmt.Sprintf("request exceeded retry limits: %s", failures[len(failures)-1])}
+}
+
+// doHTTP sends a single attempt of a request whose body is body.
+func (c *Client) doHTTP(req *http.Request, body []byte) (*http.Response, error) {
+	if pool := c.config.APIKeyPool; pool != nil {
+		if key := pool.acquire(); key != nil {
+			return c.doPooledHTTP(pool, key, req, body)
+		}
+	}
+	if c.credentials != nil {
+		return c.doAuthenticatedHTTP(req, body)
+	}
+	return c.doHedgeableHTTP(req, body)
+}
+
+func (c *Client) doHedgeableHTTP(req *http.Request, body []byte) (*http.Response, error) {
+	if hedger := c.config.Hedger; hedger != nil && isHedgeable(req.Context()) {
+		return c.doHedgedHTTP(hedger, req, body)
+	}
+	return c.doTargetHTTP(req, body)
+}
+
+func (c *Client) doTargetHTTP(req *http.Request, body []byte) (*http.Response, error) {
+	if breaker := c.config.CircuitBreaker; breaker != nil {
+		done, err := breaker.allow(req, body, c.circuitBaseURLs())
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.doLimitedHTTP(req, body)
+		done(resp, err)
+		return resp, err
+	}
+	return c.doLimitedHTTP(req, body)
+}
+
+func (c *Client) doLimitedHTTP(req *http.Request, body []byte) (*http.Response, error) {
+	limiter := c.rateLimiter(req)
+	if limiter != nil {
+		if err := limiter.wait(req.Context(), estimateTokens(body)); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if limiter != nil && resp != nil {
+		limiter.update(resp.Header)
+	}
+	return resp, err
 }
 
 func (c *Client) setCommonHeaders(req *http.Request) {
+	c.mu.RLock()
+	authToken := c.config.authToken
+	c.mu.RUnlock()
+	c.setAuthHeader(req.Header, authToken)
+	if c.config.OrgID != "" {
+		req.Header.Set("OpenAI-Organization", c.config.OrgID)
+	}
+}
+
+func (c *Client) setAuthHeader(header http.Header, authToken string) {
 	// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/reference#authentication
 	// Azure API Key authentication
 	if c.config.APIType == APITypeAzure {
-		req.Header.Set(AzureAPIKeyHeader, c.config.authToken)
-	} else if c.config.authToken != "" {
+		header.Set(AzureAPIKeyHeader, authToken)
+	} else if authToken != "" {
 		// OpenAI or Azure AD authentication
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.authToken))
-	}
-	if c.config.OrgID != "" {
-		req.Header.Set("OpenAI-Organization", c.config.OrgID)
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
 	}
 }
 
@@ -3,6 +3,7 @@ package openai
 import (
 	"net/http"
 	"regexp"
+	"time"
 )
 
 const (
@@ -35,6 +36,37 @@ type ClientConfig struct {
 	HTTPClient           *http.Client
 
 	EmptyMessagesLimit uint
+
+	// StreamFirstChunkTimeout aborts a stream with a *StreamTimeoutError if its first chunk
+	// does not arrive within this duration after the response headers. 0 means no timeout.
+	StreamFirstChunkTimeout time.Duration
+	// StreamChunkIdleTimeout aborts a stream with a *StreamTimeoutError if a chunk does not
+	// arrive within this duration after the previous one. 0 means no timeout.
+	StreamChunkIdleTimeout time.Duration
+
+	// StreamStatsHook is called with the timing data of every chat completion stream once it
+	// has ended or was closed, e.g. to export them as metrics.
+	StreamStatsHook func(ChatCompletionStreamStats)
+
+	// RateLimiter, if set, delays requests to stay within the rate limits of the API key.
+	RateLimiter *RateLimiter
+	// CircuitBreaker, if set, fails requests fast while their target keeps failing.
+	CircuitBreaker *CircuitBreaker
+	// Hedger, if set, hedges chat completion requests and the setup of chat completion
+	// streams whose response headers are late.
+	Hedger *Hedger
+	// APIKeyPool, if set, spreads the requests across several API keys instead of using the
+	// API key of the client. It takes precedence over CredentialProvider, which is not used if
+	// both are set. With a RateLimiter, every key of the pool is limited separately according
+	// to the rate limit headers of the responses to its requests.
+	APIKeyPool *APIKeyPool
+	// CredentialProvider, if set, provides the token to authenticate with instead of the API
+	// key of the client, e.g. a short-lived Azure AD token for APITypeAzureAD. It is ignored
+	// if APIKeyPool is set.
+	CredentialProvider CredentialProvider
+
+	// Middleware wraps every call of the client, the first one outermost.
+	Middleware []Middleware
 }
 
 func DefaultConfig(authToken string) ClientConfig {
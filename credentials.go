@@ -0,0 +1,284 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// credentialRefreshWindow is how long before it expires a credential is refreshed at most.
+	credentialRefreshWindow = 5 * time.Minute
+	// credentialRefreshTimeout bounds a single call of a CredentialProvider.
+	credentialRefreshTimeout = time.Minute
+)
+
+// Credential is a token the client authenticates with.
+type Credential struct {
+	Token string
+	// ExpiresAt is the time the token expires, zero if it does not.
+	ExpiresAt time.Time
+}
+
+// CredentialProvider provides the token the client authenticates with, see
+// ClientConfig.CredentialProvider. The client caches the credential and calls Credential
+// again shortly before it expires, or when a request was rejected with 401. Implementations
+// must be safe for concurrent use.
+type CredentialProvider interface {
+	// Credential fetches a new credential.
+	Credential(ctx context.Context) (Credential, error)
+}
+
+// StaticCredentialProvider provides a fixed API key that never expires.
+type StaticCredentialProvider string
+
+func (p StaticCredentialProvider) Credential(context.Context) (Credential, error) {
+	return Credential{Token: string(p)}, nil
+}
+
+// OAuth2ClientCredentialsProvider fetches access tokens from an OAuth2 token endpoint with the
+// client credentials grant, e.g. Microsoft Entra ID for APITypeAzureAD with the scope
+// "https://cognitiveservices.azure.com/.default".
+type OAuth2ClientCredentialsProvider struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams are sent to the token endpoint in addition to the standard parameters.
+	EndpointParams url.Values
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (p *OAuth2ClientCredentialsProvider) Credential(ctx context.Context) (Credential, error) {
+	form := url.Values{}
+	for k, v := range p.EndpointParams {
+		form[k] = v
+	}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	if len(p.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credential{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Credential{}, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var token struct {
+		AccessToken      string          `json:"access_token"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return Credential{}, fmt.Errorf("token endpoint returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return Credential{}, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return Credential{}, errors.New("token endpoint returned no access token")
+	}
+
+	credential := Credential{Token: token.AccessToken}
+	// some endpoints, e.g. the Azure AD v1 one, send expires_in as a string
+	expiresIn, err := strconv.Atoi(strings.Trim(string(token.ExpiresIn), `"`))
+	if err == nil && expiresIn > 0 {
+		credential.ExpiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return credential, nil
+}
+
+// ExecCredentialProvider runs a helper command that prints a credential in the ExecCredential
+// format of kubectl's exec credential plugins to its standard output:
+//
+//	{"apiVersion": "client.authentication.k8s.io/v1", "kind": "ExecCredential",
+//	 "status": {"token": "...", "expirationTimestamp": "2024-01-01T00:00:00Z"}}
+type ExecCredentialProvider struct {
+	Command string
+	Args    []string
+	// Env is added to the environment of the command.
+	Env []string
+	// Dir is the working directory of the command, the current one if empty.
+	Dir string
+}
+
+func (p *ExecCredentialProvider) Credential(ctx context.Context) (Credential, error) {
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Env = append(os.Environ(), p.Env...)
+	cmd.Dir = p.Dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return Credential{}, fmt.Errorf("credential command %s failed: %w: %s", p.Command, err, msg)
+		}
+		return Credential{}, fmt.Errorf("credential command %s failed: %w", p.Command, err)
+	}
+
+	var output struct {
+		Kind   string `json:"kind"`
+		Status *struct {
+			Token               string    `json:"token"`
+			ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return Credential{}, fmt.Errorf("credential command %s printed an invalid ExecCredential: %w", p.Command, err)
+	}
+	if output.Kind != "ExecCredential" || output.Status == nil || output.Status.Token == "" {
+		return Credential{}, fmt.Errorf("credential command %s printed no token", p.Command)
+	}
+	return Credential{Token: output.Status.Token, ExpiresAt: output.Status.ExpirationTimestamp}, nil
+}
+
+// credentialCache caches the credential of a provider and makes sure only one refresh is in
+// flight at a time.
+type credentialCache struct {
+	provider CredentialProvider
+	// now returns the current time, it is replaced in tests.
+	now func() time.Time
+
+	mu         sync.Mutex
+	credential Credential
+	fetchedAt  time.Time
+	refresh    *credentialRefresh
+}
+
+type credentialRefresh struct {
+	done       chan struct{}
+	credential Credential
+	err        error
+}
+
+func newCredentialCache(provider CredentialProvider) *credentialCache {
+	return &credentialCache{provider: provider, now: time.Now}
+}
+
+// token returns a valid token. A token that was rejected can be passed as stale to force a
+// refresh unless that already happened. A token that expires soon is refreshed in the
+// background while it is still used.
+func (c *credentialCache) token(ctx context.Context, stale string) (string, error) {
+	c.mu.Lock()
+	now := c.now()
+	credential := c.credential
+	if credential.Token != "" && credential.Token != stale &&
+		(credential.ExpiresAt.IsZero() || now.Before(credential.ExpiresAt)) {
+		if c.refreshDue(now) && c.refresh == nil {
+			c.startRefresh()
+		}
+		c.mu.Unlock()
+		return credential.Token, nil
+	}
+
+	refresh := c.refresh
+	if refresh == nil {
+		refresh = c.startRefresh()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-refresh.done:
+	}
+	if refresh.err != nil {
+		return "", fmt.Errorf("failed to get credential: %w", refresh.err)
+	}
+	return refresh.credential.Token, nil
+}
+
+// refreshDue reports whether the credential expires within the refresh window, which is at
+// most half of its lifetime. It must be called with c.mu held.
+func (c *credentialCache) refreshDue(now time.Time) bool {
+	if c.credential.ExpiresAt.IsZero() {
+		return false
+	}
+	window := min(credentialRefreshWindow, c.credential.ExpiresAt.Sub(c.fetchedAt)/2)
+	return now.After(c.credential.ExpiresAt.Add(-window))
+}
+
+// startRefresh fetches a new credential in the background. It must be called with c.mu held.
+// The fetch is not bound to the context of a request, so that other requests waiting for it
+// are not failed when that request is canceled.
+func (c *credentialCache) startRefresh() *credentialRefresh {
+	refresh := &credentialRefresh{done: make(chan struct{})}
+	c.refresh = refresh
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), credentialRefreshTimeout)
+		defer cancel()
+		credential, err := c.provider.Credential(ctx)
+		if err == nil && credential.Token == "" {
+			err = errors.New("credential provider returned an empty token")
+		}
+
+		c.mu.Lock()
+		if err == nil {
+			c.credential, c.fetchedAt = credential, c.now()
+		}
+		refresh.credential, refresh.err = credential, err
+		c.refresh = nil
+		c.mu.Unlock()
+		close(refresh.done)
+	}()
+	return refresh
+}
+
+// doAuthenticatedHTTP sends a single attempt of a request with the token of the credential
+// provider. A 401 response forces a refresh of the token and is retried once with the new one.
+func (c *Client) doAuthenticatedHTTP(req *http.Request, body []byte) (*http.Response, error) {
+	token, err := c.credentials.token(req.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	c.setAuthHeader(req.Header, token)
+
+	resp, err := c.doHedgeableHTTP(req, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the token may have been revoked or expired early
+	refreshed, refreshErr := c.credentials.token(req.Context(), token)
+	if refreshErr != nil || refreshed == token {
+		return resp, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	c.setAuthHeader(req.Header, refreshed)
+	return c.doHedgeableHTTP(req, body)
+}
@@ -0,0 +1,230 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sequenceCredentialProvider provides the tokens token-1, token-2, ... in turn.
+type sequenceCredentialProvider struct {
+	calls atomic.Int32
+}
+
+func (p *sequenceCredentialProvider) Credential(context.Context) (Credential, error) {
+	// a slow provider makes concurrent refreshes likely if they are not deduplicated
+	time.Sleep(20 * time.Millisecond)
+	return Credential{Token: fmt.Sprintf("token-%d", p.calls.Add(1))}, nil
+}
+
+// rejectTokens returns the status of a chat completion request that is rejected with 401 if
+// it is authenticated with one of tokens.
+func rejectTokens(tokens ...string) func(*http.Request, []byte) int {
+	return func(r *http.Request, _ []byte) int {
+		if tokens == nil || slices.Contains(tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+			return http.StatusUnauthorized
+		}
+		return http.StatusOK
+	}
+}
+
+// checkBodies fails the test if the server received a request without the body of a chat
+// completion request for GPT4o.
+func checkBodies(t *testing.T, server *chatCompletionServer) {
+	t.Helper()
+	for _, received := range server.requests() {
+		var request ChatCompletionRequest
+		if err := json.Unmarshal(received.body, &request); err != nil || request.Model != GPT4o {
+			t.Errorf("request body was not sent: %q", received.body)
+		}
+	}
+}
+
+func TestOAuth2ClientCredentialsProviderCachesUntilExpiry(t *testing.T) {
+	var tokens atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "id" ||
+			r.Form.Get("client_secret") != "secret" || r.Form.Get("scope") != "a b" {
+			t.Errorf("unexpected token request %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// Azure AD v1 sends expires_in as a string
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":"3600"}`, tokens.Add(1))
+	}))
+	defer tokenServer.Close()
+
+	server := &chatCompletionServer{}
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.CredentialProvider = &OAuth2ClientCredentialsProvider{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "id",
+			ClientSecret: "secret",
+			Scopes:       []string{"a", "b"},
+		}
+	})
+	var (
+		mu  sync.Mutex
+		now = time.Now()
+	)
+	client.credentials.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	request := ChatCompletionRequest{Model: GPT4o}
+	for range 3 {
+		if _, err := client.CreateChatCompletion(context.Background(), request, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := tokens.Load(); got != 1 {
+		t.Errorf("fetched %d tokens before the first one expired, want 1", got)
+	}
+
+	mu.Lock()
+	now = now.Add(time.Hour + time.Second)
+	mu.Unlock()
+	if _, err := client.CreateChatCompletion(context.Background(), request, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens.Load(); got != 2 {
+		t.Errorf("fetched %d tokens after the first one expired, want 2", got)
+	}
+	var used []string
+	for _, received := range server.requests() {
+		used = append(used, strings.TrimPrefix(received.header.Get("Authorization"), "Bearer "))
+	}
+	want := []string{"token-1", "token-1", "token-1", "token-2"}
+	if !slices.Equal(used, want) {
+		t.Errorf("requests were sent with %v, want %v", used, want)
+	}
+}
+
+func TestOAuth2ClientCredentialsProviderError(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"unknown client"}`))
+	}))
+	defer tokenServer.Close()
+
+	provider := &OAuth2ClientCredentialsProvider{TokenURL: tokenServer.URL, ClientID: "id"}
+	_, err := provider.Credential(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_client unknown client") {
+		t.Errorf("got error %v, want the error of the token endpoint", err)
+	}
+}
+
+// TestExecCredentialHelper is not a real test but the credential command run by the tests of
+// ExecCredentialProvider.
+func TestExecCredentialHelper(t *testing.T) {
+	mode := os.Getenv("EXEC_CREDENTIAL_HELPER")
+	switch mode {
+	case "":
+		return
+	case "fail":
+		fmt.Fprint(os.Stderr, "not logged in")
+		os.Exit(1)
+	case "token":
+		fmt.Print(`{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential",` +
+			`"status":{"token":"exec-token","expirationTimestamp":"2030-01-01T00:00:00Z"}}`)
+	case "empty":
+		fmt.Print(`{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{}}`)
+	}
+	os.Exit(0)
+}
+
+func TestExecCredentialProvider(t *testing.T) {
+	provider := func(mode string) *ExecCredentialProvider {
+		return &ExecCredentialProvider{
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^TestExecCredentialHelper$"},
+			Env:     []string{"EXEC_CREDENTIAL_HELPER=" + mode},
+		}
+	}
+
+	credential, err := provider("token").Credential(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Credential{Token: "exec-token", ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if credential.Token != want.Token || !credential.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("got credential %+v, want %+v", credential, want)
+	}
+
+	_, err = provider("fail").Credential(context.Background())
+	if err == nil || !strings.HasSuffix(err.Error(), ": not logged in") {
+		t.Errorf("got error %v, want the standard error of the command", err)
+	}
+
+	_, err = provider("empty").Credential(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "printed no token") {
+		t.Errorf("got error %v, want an error for the missing token", err)
+	}
+}
+
+func TestCredentialRefreshOnUnauthorized(t *testing.T) {
+	provider := &sequenceCredentialProvider{}
+	// the first token is revoked
+	server := &chatCompletionServer{status: rejectTokens("token-1")}
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.CredentialProvider = provider
+	})
+
+	const concurrency = 5
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the initial fetch and a single refresh shared by all rejected requests
+	if got := provider.calls.Load(); got != 2 {
+		t.Errorf("credential provider was called %d times, want 2", got)
+	}
+	if got := len(server.requests()); got != 2*concurrency {
+		t.Errorf("server received %d requests, want %d", got, 2*concurrency)
+	}
+	checkBodies(t, server)
+}
+
+func TestCredentialRetriesUnauthorizedOnce(t *testing.T) {
+	provider := &sequenceCredentialProvider{}
+	server := &chatCompletionServer{status: rejectTokens()}
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.CredentialProvider = provider
+	})
+
+	_, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusUnauthorized {
+		t.Fatalf("got error %v, want the 401 response", err)
+	}
+	if got := len(server.requests()); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+	checkBodies(t, server)
+	if got := provider.calls.Load(); got != 2 {
+		t.Errorf("credential provider was called %d times, want 2", got)
+	}
+}
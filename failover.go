@@ -0,0 +1,318 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint is a target of a FailoverClient, e.g. an Azure deployment in one region, with its
+// own base URL, API type, authentication, API version and model mapping.
+type Endpoint struct {
+	Name   string
+	Config ClientConfig
+	// ModelMapper, if set, maps the model of a request to the model the endpoint serves it
+	// with, e.g. "gpt-4o" to "openai/gpt-4o" for a compatible backend. For Azure, the mapped
+	// model is then mapped to a deployment by Config.AzureModelMapperFunc.
+	ModelMapper func(model string) string
+}
+
+// EndpointHealth is the health of an endpoint of a FailoverClient.
+type EndpointHealth struct {
+	Name    string
+	Healthy bool
+	// Failures is the number of consecutive failed requests.
+	Failures int
+	// LastError is the error of the last failed request.
+	LastError error
+}
+
+// FailoverConfig configures a FailoverClient. Zero values are replaced by the defaults.
+type FailoverConfig struct {
+	// FailureThreshold is the number of consecutive failures after which an endpoint is
+	// marked unhealthy. Defaults to 3.
+	FailureThreshold int
+	// ProbeInterval is the time an unhealthy endpoint is skipped before a single request is
+	// sent to it as a probe. The endpoint is healthy again once a probe succeeds. Defaults to
+	// 30 seconds.
+	ProbeInterval time.Duration
+
+	// OnHealthChange is called whenever an endpoint becomes unhealthy or healthy again.
+	OnHealthChange func(EndpointHealth)
+}
+
+// FailoverError is returned when none of the endpoints of a FailoverClient served a request.
+// It unwraps to the error of every endpoint tried.
+type FailoverError struct {
+	Errors []EndpointError
+}
+
+// EndpointError is the error a request to an endpoint failed with.
+type EndpointError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *FailoverError) Error() string {
+	if len(e.Errors) == 0 {
+		return "no endpoint available"
+	}
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", err.Endpoint, err.Err))
+	}
+	return fmt.Sprintf("all endpoints failed: %s", strings.Join(msgs, "; "))
+}
+
+func (e *FailoverError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err.Err)
+	}
+	return errs
+}
+
+// FailoverClient sends chat completion requests to the first healthy one of an ordered list
+// of endpoints and fails over to the next one on connection errors, 5xx and 429 responses and
+// open circuits. Health is tracked passively from the outcome of the requests: connection
+// errors and 5xx responses count as failures. Retries configured through RetryOptions happen
+// on each endpoint before failing over.
+type FailoverClient struct {
+	config    FailoverConfig
+	endpoints []*failoverEndpoint
+
+	mu sync.Mutex
+}
+
+type failoverEndpoint struct {
+	name        string
+	client      *Client
+	modelMapper func(string) string
+
+	healthy   bool
+	failures  int
+	lastError error
+	// unhealthyAt is the time the endpoint became unhealthy or the last probe failed.
+	unhealthyAt time.Time
+	probing     bool
+}
+
+func NewFailoverClient(config FailoverConfig, endpoints ...Endpoint) *FailoverClient {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 3
+	}
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = 30 * time.Second
+	}
+
+	c := &FailoverClient{config: config}
+	for _, endpoint := range endpoints {
+		c.endpoints = append(c.endpoints, &failoverEndpoint{
+			name:        endpoint.Name,
+			client:      NewClientWithConfig(endpoint.Config),
+			modelMapper: endpoint.ModelMapper,
+			healthy:     true,
+		})
+	}
+	return c
+}
+
+// Health returns the health of the endpoints in their order.
+func (c *FailoverClient) Health() []EndpointHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	health := make([]EndpointHealth, 0, len(c.endpoints))
+	for _, endpoint := range c.endpoints {
+		health = append(health, endpoint.health())
+	}
+	return health
+}
+
+// CreateChatCompletion creates a chat completion on the first endpoint that serves it. The
+// Endpoint field of the response is set to the name of that endpoint.
+func (c *FailoverClient) CreateChatCompletion(
+	ctx context.Context,
+	request ChatCompletionRequest,
+	headers map[string]string,
+	retryOpts ...RetryOptions,
+) (response ChatCompletionResponse, err error) {
+	err = c.failover(ctx, func(endpoint *failoverEndpoint) error {
+		var err error
+		response, err = endpoint.client.CreateChatCompletion(ctx, endpoint.mapRequest(request), headers, retryOpts...)
+		if err == nil {
+			response.Endpoint = endpoint.name
+		}
+		return err
+	})
+	return
+}
+
+// CreateChatCompletionStream sets up a chat completion stream on the first endpoint that
+// serves it, see (*ChatCompletionStream).Endpoint. A stream that breaks after it was set up
+// is resumed on the same endpoint.
+func (c *FailoverClient) CreateChatCompletionStream(
+	ctx context.Context,
+	request ChatCompletionRequest,
+	headers map[string]string,
+	retryOpts ...RetryOptions,
+) (stream *ChatCompletionStream, err error) {
+	err = c.failover(ctx, func(endpoint *failoverEndpoint) error {
+		var err error
+		stream, err = endpoint.client.CreateChatCompletionStream(ctx, endpoint.mapRequest(request), headers, retryOpts...)
+		if stream != nil {
+			stream.endpoint = endpoint.name
+		}
+		return err
+	})
+	return
+}
+
+// failover calls do with the endpoints in order until one succeeds or fails with an error
+// that another endpoint would not fix. Unhealthy endpoints are skipped unless a probe is due,
+// or all endpoints are unhealthy.
+func (c *FailoverClient) failover(ctx context.Context, do func(*failoverEndpoint) error) error {
+	var (
+		errs  []EndpointError
+		tried = map[*failoverEndpoint]bool{}
+	)
+	try := func(endpoint *failoverEndpoint, probe bool) (bool, error) {
+		tried[endpoint] = true
+		err := do(endpoint)
+		next, failed := classifyEndpointError(ctx, err)
+		c.record(endpoint, probe, err, failed)
+		if err != nil && next {
+			errs = append(errs, EndpointError{Endpoint: endpoint.name, Err: err})
+			return false, nil
+		}
+		return true, err
+	}
+
+	for _, endpoint := range c.endpoints {
+		admitted, probe := c.admit(endpoint)
+		if !admitted {
+			continue
+		}
+		if done, err := try(endpoint, probe); done {
+			return err
+		}
+	}
+	// all endpoints are unhealthy, so try the ones skipped anyway rather than failing outright
+	for _, endpoint := range c.endpoints {
+		if tried[endpoint] {
+			continue
+		}
+		if done, err := try(endpoint, false); done {
+			return err
+		}
+	}
+	return &FailoverError{Errors: errs}
+}
+
+// admit reports whether a request may be sent to the endpoint and whether it is a probe.
+func (c *FailoverClient) admit(endpoint *failoverEndpoint) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if endpoint.healthy {
+		return true, false
+	}
+	if endpoint.probing || time.Since(endpoint.unhealthyAt) < c.config.ProbeInterval {
+		return false, false
+	}
+	endpoint.probing = true
+	return true, true
+}
+
+func (c *FailoverClient) record(endpoint *failoverEndpoint, probe bool, err error, failed bool) {
+	c.mu.Lock()
+	if probe {
+		endpoint.probing = false
+	}
+
+	var changed bool
+	switch {
+	case failed:
+		endpoint.failures++
+		endpoint.lastError = err
+		if !endpoint.healthy {
+			// a failed probe or request restarts the probe interval
+			endpoint.unhealthyAt = time.Now()
+		} else if endpoint.failures >= c.config.FailureThreshold {
+			endpoint.healthy = false
+			endpoint.unhealthyAt = time.Now()
+			changed = true
+		}
+	case err == nil:
+		endpoint.failures = 0
+		if !endpoint.healthy {
+			endpoint.healthy = true
+			changed = true
+		}
+	}
+	health := endpoint.health()
+	c.mu.Unlock()
+
+	if changed && c.config.OnHealthChange != nil {
+		c.config.OnHealthChange(health)
+	}
+}
+
+// mapRequest returns the request with the model the endpoint serves it with.
+func (e *failoverEndpoint) mapRequest(request ChatCompletionRequest) ChatCompletionRequest {
+	if e.modelMapper != nil {
+		request.Model = e.modelMapper(request.Model)
+	}
+	return request
+}
+
+func (e *failoverEndpoint) health() EndpointHealth {
+	return EndpointHealth{
+		Name:      e.name,
+		Healthy:   e.healthy,
+		Failures:  e.failures,
+		LastError: e.lastError,
+	}
+}
+
+// classifyEndpointError reports whether a request that failed with err should fail over to
+// the next endpoint and whether the failure counts against the health of the endpoint.
+func classifyEndpointError(ctx context.Context, err error) (next bool, failed bool) {
+	if err == nil || ctx.Err() != nil {
+		return false, false
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return true, false
+	}
+
+	var statusCode int
+	var exhausted *RetryExhaustedError
+	var apiErr *APIError
+	var reqErr *RequestError
+	switch {
+	case errors.As(err, &exhausted) && len(exhausted.Attempts) > 0:
+		last := exhausted.Attempts[len(exhausted.Attempts)-1]
+		if last.Err != nil {
+			// a connection error or a stream whose first chunk timed out
+			return true, true
+		}
+		statusCode = last.StatusCode
+	case errors.As(err, &apiErr):
+		statusCode = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		statusCode = reqErr.HTTPStatusCode
+	default:
+		// e.g. a request that cannot be marshaled, which no endpoint would accept
+		return false, false
+	}
+
+	switch {
+	case statusCode >= http.StatusInternalServerError:
+		return true, true
+	case statusCode == http.StatusTooManyRequests:
+		return true, false
+	}
+	return false, false
+}
@@ -0,0 +1,234 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingServer returns a server that answers with status while failing is set.
+func failingServer(status int, failing *atomic.Bool) *chatCompletionServer {
+	return &chatCompletionServer{status: func(*http.Request, []byte) int {
+		if failing.Load() {
+			return status
+		}
+		return http.StatusOK
+	}}
+}
+
+func TestFailoverClientTriesEndpointsInOrder(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	primary, secondary := failingServer(http.StatusInternalServerError, &failing), &chatCompletionServer{}
+	client := NewFailoverClient(FailoverConfig{},
+		Endpoint{Name: "primary", Config: newTestConfig(t, primary)},
+		Endpoint{Name: "secondary", Config: newTestConfig(t, secondary)},
+	)
+
+	response, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Endpoint != "secondary" {
+		t.Errorf("got endpoint %q, want secondary", response.Endpoint)
+	}
+	if len(primary.requests()) != 1 || len(secondary.requests()) != 1 {
+		t.Errorf("got %d and %d requests, want 1 and 1", len(primary.requests()), len(secondary.requests()))
+	}
+
+	failing.Store(false)
+	response, err = client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Endpoint != "primary" {
+		t.Errorf("got endpoint %q, want primary", response.Endpoint)
+	}
+}
+
+func TestFailoverClientFailsOverStream(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	client := NewFailoverClient(FailoverConfig{},
+		Endpoint{Name: "primary", Config: newTestConfig(t, failingServer(http.StatusBadGateway, &failing))},
+		Endpoint{Name: "secondary", Config: newTestConfig(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			data, _ := marshalEventData(newStreamChunkData(contentChunk(0, "Hello")))
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+		}))},
+	)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if stream.Endpoint() != "secondary" {
+		t.Errorf("got endpoint %q, want secondary", stream.Endpoint())
+	}
+	chunk, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Choices[0].Delta.Content != "Hello" {
+		t.Errorf("got content %q, want Hello", chunk.Choices[0].Delta.Content)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("got error %v, want EOF", err)
+	}
+}
+
+func TestFailoverClientEjectsAndRecoversEndpoint(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	primary, secondary := failingServer(http.StatusServiceUnavailable, &failing), &chatCompletionServer{}
+	var (
+		mu      sync.Mutex
+		changes []EndpointHealth
+	)
+	client := NewFailoverClient(FailoverConfig{
+		FailureThreshold: 2,
+		ProbeInterval:    50 * time.Millisecond,
+		OnHealthChange: func(health EndpointHealth) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, health)
+		},
+	},
+		Endpoint{Name: "primary", Config: newTestConfig(t, primary)},
+		Endpoint{Name: "secondary", Config: newTestConfig(t, secondary)},
+	)
+	send := func() string {
+		t.Helper()
+		response, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Endpoint
+	}
+
+	for range 4 {
+		if endpoint := send(); endpoint != "secondary" {
+			t.Fatalf("got endpoint %q, want secondary", endpoint)
+		}
+	}
+	// the primary is skipped once it failed twice
+	if got := len(primary.requests()); got != 2 {
+		t.Errorf("primary received %d requests, want 2", got)
+	}
+	if health := client.Health()[0]; health.Healthy || health.Failures != 2 || health.LastError == nil {
+		t.Errorf("got health %+v, want unhealthy after 2 failures", health)
+	}
+
+	// a probe that fails restarts the interval
+	time.Sleep(60 * time.Millisecond)
+	if endpoint := send(); endpoint != "secondary" {
+		t.Fatalf("got endpoint %q, want secondary", endpoint)
+	}
+	if got := len(primary.requests()); got != 3 {
+		t.Errorf("primary received %d requests, want 3", got)
+	}
+	send()
+	if got := len(primary.requests()); got != 3 {
+		t.Errorf("primary received %d requests, want no probe before the interval passed", got)
+	}
+
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if endpoint := send(); endpoint != "primary" {
+		t.Errorf("got endpoint %q, want primary after a successful probe", endpoint)
+	}
+	if health := client.Health()[0]; !health.Healthy || health.Failures != 0 {
+		t.Errorf("got health %+v, want healthy", health)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 2 || changes[0].Healthy || !changes[1].Healthy {
+		t.Errorf("got health changes %+v, want unhealthy then healthy", changes)
+	}
+}
+
+func TestFailoverClientRateLimitIsNotAFailure(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	primary := failingServer(http.StatusTooManyRequests, &failing)
+	client := NewFailoverClient(FailoverConfig{FailureThreshold: 1},
+		Endpoint{Name: "primary", Config: newTestConfig(t, primary)},
+		Endpoint{Name: "secondary", Config: newTestConfig(t, &chatCompletionServer{})},
+	)
+
+	for range 3 {
+		response, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Endpoint != "secondary" {
+			t.Errorf("got endpoint %q, want secondary", response.Endpoint)
+		}
+	}
+	if got := len(primary.requests()); got != 3 {
+		t.Errorf("primary received %d requests, want 3", got)
+	}
+	if health := client.Health()[0]; !health.Healthy || health.Failures != 0 {
+		t.Errorf("got health %+v, want healthy", health)
+	}
+}
+
+func TestFailoverClientReturnsErrorOfEveryEndpoint(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	client := NewFailoverClient(FailoverConfig{},
+		Endpoint{Name: "primary", Config: newTestConfig(t, failingServer(http.StatusInternalServerError, &failing))},
+		Endpoint{Name: "secondary", Config: newTestConfig(t, failingServer(http.StatusBadGateway, &failing))},
+	)
+
+	_, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) {
+		t.Fatalf("got error %v, want a FailoverError", err)
+	}
+	if len(failoverErr.Errors) != 2 || failoverErr.Errors[0].Endpoint != "primary" || failoverErr.Errors[1].Endpoint != "secondary" {
+		t.Errorf("got errors %+v, want one of each endpoint in order", failoverErr.Errors)
+	}
+}
+
+func TestFailoverClientMapsModel(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	primary, secondary := failingServer(http.StatusInternalServerError, &failing), &chatCompletionServer{}
+	client := NewFailoverClient(FailoverConfig{},
+		Endpoint{Name: "primary", Config: newTestConfig(t, primary)},
+		Endpoint{
+			Name:        "secondary",
+			Config:      newTestConfig(t, secondary),
+			ModelMapper: func(model string) string { return "openai/" + model },
+		},
+	)
+
+	if _, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		server *chatCompletionServer
+		want   string
+	}{
+		{primary, GPT4o},
+		{secondary, "openai/" + GPT4o},
+	} {
+		var request ChatCompletionRequest
+		if err := json.Unmarshal(tt.server.requests()[0].body, &request); err != nil {
+			t.Fatal(err)
+		}
+		if request.Model != tt.want {
+			t.Errorf("got model %q, want %q", request.Model, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HedgeTarget is an alternate target of hedge requests. Empty fields are taken from the client.
+type HedgeTarget struct {
+	BaseURL string
+	APIKey  string
+}
+
+// HedgingConfig configures a Hedger. Zero values are replaced by the defaults.
+type HedgingConfig struct {
+	// Delay is the time to wait for the response headers of a request before a hedge request
+	// is sent. With Percentile set, it is only used until MinSamples latencies were observed.
+	// 0 means requests are not hedged until then.
+	Delay time.Duration
+	// Percentile, e.g. 0.95, sends the hedge request once a request has taken longer than this
+	// percentile of the time to the response headers of recent requests.
+	Percentile float64
+	// MinSamples is the number of latencies that must be observed before Percentile is used.
+	// Defaults to 20.
+	MinSamples int
+	// Samples is the number of recent latencies Percentile is computed from. Defaults to 100.
+	Samples int
+	// MaxHedges is the maximum number of hedge requests in flight at once. Requests are not
+	// hedged while it is reached. Defaults to 10.
+	MaxHedges int
+	// Targets are sent the hedge requests in turn. Without targets, the hedge request is a
+	// duplicate of the original one.
+	Targets []HedgeTarget
+}
+
+// Hedger sends a duplicate of a chat completion request, or the setup request of a chat
+// completion stream, that has not received its response headers after a delay, see
+// ClientConfig.Hedger. The first successful response wins and the other request is canceled.
+// Hedge requests pass the circuit breaker and the rate limiter of the client like any other
+// request. The MaxHedges cap is shared by all clients using the same Hedger.
+type Hedger struct {
+	config HedgingConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	inFlight  int
+	target    int
+}
+
+func NewHedger(config HedgingConfig) *Hedger {
+	if config.MinSamples <= 0 {
+		config.MinSamples = 20
+	}
+	if config.Samples <= 0 {
+		config.Samples = 100
+	}
+	config.Samples = max(config.Samples, config.MinSamples)
+	if config.MaxHedges <= 0 {
+		config.MaxHedges = 10
+	}
+	return &Hedger{config: config}
+}
+
+// delay returns the time to wait before a request is hedged, or 0 if it is not hedged.
+func (h *Hedger) delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.config.Percentile <= 0 || len(h.latencies) < h.config.MinSamples {
+		return h.config.Delay
+	}
+
+	sorted := slices.Clone(h.latencies)
+	slices.Sort(sorted)
+	i := int(math.Ceil(min(h.config.Percentile, 1)*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// observe records the time a request took to receive its response headers.
+func (h *Hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < h.config.Samples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % len(h.latencies)
+}
+
+// acquire reserves a hedge request and picks its target. It reports false if MaxHedges is reached.
+func (h *Hedger) acquire() (HedgeTarget, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inFlight >= h.config.MaxHedges {
+		return HedgeTarget{}, false
+	}
+	h.inFlight++
+
+	var target HedgeTarget
+	if len(h.config.Targets) > 0 {
+		target = h.config.Targets[h.target%len(h.config.Targets)]
+		h.target++
+	}
+	return target, true
+}
+
+func (h *Hedger) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inFlight--
+}
+
+type hedgeContextKey struct{}
+
+// withHedging marks the requests made with ctx as eligible for hedging.
+func withHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgeContextKey{}, true)
+}
+
+func isHedgeable(ctx context.Context) bool {
+	hedgeable, _ := ctx.Value(hedgeContextKey{}).(bool)
+	return hedgeable
+}
+
+type hedgeResult struct {
+	// index is the position of the request, 0 for the original one.
+	index   int
+	resp    *http.Response
+	err     error
+	cancel  context.CancelFunc
+	latency time.Duration
+}
+
+// doHedgedHTTP sends a single attempt of a request whose body is body and hedges it if it
+// has not received its response headers in time.
+func (c *Client) doHedgedHTTP(hedger *Hedger, req *http.Request, body []byte) (*http.Response, error) {
+	delay := hedger.delay()
+	if delay <= 0 {
+		start := time.Now()
+		resp, err := c.doTargetHTTP(req, body)
+		if err == nil && !isFailureStatusCode(resp) {
+			hedger.observe(time.Since(start))
+		}
+		return resp, err
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	send := func(req *http.Request, cancel context.CancelFunc) {
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			resp, err := c.doTargetHTTP(req, body)
+			if index > 0 {
+				hedger.release()
+			}
+			results <- hedgeResult{index: index, resp: resp, err: err, cancel: cancel, latency: time.Since(start)}
+		}()
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(req.Context())
+	send(req.WithContext(ctx), cancel)
+	pending := 1
+	primaryDone := false
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var failed *hedgeResult
+	for {
+		select {
+		case <-timer.C:
+			target, ok := hedger.acquire()
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithCancel(req.Context())
+			send(c.newHedgeRequest(ctx, req, body, target), cancel)
+			pending++
+		case result := <-results:
+			pending--
+			primaryDone = primaryDone || result.index == 0
+			if result.err == nil && !isFailureStatusCode(result.resp) {
+				// the delay is derived from the latencies of original requests only, a hedge
+				// that wins cuts the original short, which then took at least until now
+				switch {
+				case result.index == 0:
+					hedger.observe(result.latency)
+				case !primaryDone:
+					hedger.observe(time.Since(start))
+				}
+				if failed != nil {
+					failed.close()
+				}
+				discardHedgeResults(results, pending, cancels, result.index)
+				result.resp.Body = &cancelOnClose{ReadCloser: result.resp.Body, cancel: result.cancel}
+				return result.resp, nil
+			}
+
+			if failed != nil {
+				failed.close()
+			}
+			failed = &result
+			if pending > 0 {
+				// the other request may still succeed
+				continue
+			}
+			if failed.resp == nil {
+				failed.cancel()
+				return nil, failed.err
+			}
+			// error responses are small, buffer them so the request can be released right away
+			data, _ := io.ReadAll(failed.resp.Body)
+			failed.close()
+			failed.resp.Body = io.NopCloser(bytes.NewReader(data))
+			return failed.resp, nil
+		}
+	}
+}
+
+// newHedgeRequest copies req for a hedge request to target.
+func (c *Client) newHedgeRequest(ctx context.Context, req *http.Request, body []byte, target HedgeTarget) *http.Request {
+	hedge := req.Clone(ctx)
+	if body != nil {
+		hedge.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if target.BaseURL != "" && strings.HasPrefix(req.URL.String(), c.config.BaseURL) {
+		u, err := url.Parse(target.BaseURL + strings.TrimPrefix(req.URL.String(), c.config.BaseURL))
+		if err == nil {
+			hedge.URL = u
+			hedge.Host = ""
+		}
+	}
+	if target.APIKey != "" {
+		c.setAuthHeader(hedge.Header, target.APIKey)
+	}
+	return hedge
+}
+
+// discardHedgeResults cancels the requests other than the winning one and releases their
+// responses once they arrive.
+func discardHedgeResults(results <-chan hedgeResult, pending int, cancels []context.CancelFunc, winner int) {
+	if pending == 0 {
+		return
+	}
+	for i, cancel := range cancels {
+		if i != winner {
+			cancel()
+		}
+	}
+	go func() {
+		for range pending {
+			result := <-results
+			result.close()
+		}
+	}()
+}
+
+func (r *hedgeResult) close() {
+	if r.resp != nil {
+		_ = r.resp.Body.Close()
+	}
+	r.cancel()
+}
+
+// cancelOnClose cancels the context of a request once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
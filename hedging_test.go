@@ -0,0 +1,248 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hedgeServer answers the n-th request it receives after wait(n) returned the status of the
+// response. The response carries the position of the request in the X-Request header.
+type hedgeServer struct {
+	wait func(r *http.Request, n int) int
+
+	requests atomic.Int32
+}
+
+func (s *hedgeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	n := int(s.requests.Add(1))
+	status := s.wait(r, n)
+	w.Header().Set("X-Request", strconv.Itoa(n))
+	w.Header().Set("Content-Type", "application/json")
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{"error":{"message":"`+http.StatusText(status)+`","type":"server_error"}}`)
+		return
+	}
+	_, _ = io.WriteString(w, `{"id":"chatcmpl-123","object":"chat.completion","choices":[]}`)
+}
+
+// waitFor blocks for d or until the request is canceled and reports whether it was canceled.
+func waitFor(r *http.Request, d time.Duration) bool {
+	select {
+	case <-r.Context().Done():
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (h *Hedger) observed() []time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]time.Duration(nil), h.latencies...)
+}
+
+func (h *Hedger) hedgesInFlight() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.inFlight
+}
+
+func sendHedged(t *testing.T, hedger *Hedger, server *hedgeServer) (ChatCompletionResponse, error) {
+	t.Helper()
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.Hedger = hedger
+	})
+	return client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil)
+}
+
+func TestHedgeWinsOverSlowRequest(t *testing.T) {
+	canceled := make(chan struct{})
+	server := &hedgeServer{wait: func(r *http.Request, n int) int {
+		if n == 1 && waitFor(r, 5*time.Second) {
+			close(canceled)
+		}
+		return http.StatusOK
+	}}
+	hedger := NewHedger(HedgingConfig{Delay: 20 * time.Millisecond})
+
+	response, err := sendHedged(t, hedger, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header().Get("X-Request"); got != "2" {
+		t.Errorf("got response to request %s, want the hedge", got)
+	}
+
+	// the original request is canceled and its slot released
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the original request was not canceled")
+	}
+	if got := hedger.hedgesInFlight(); got != 0 {
+		t.Errorf("got %d hedges in flight, want 0", got)
+	}
+	// the original request took at least until the hedge won
+	if latencies := hedger.observed(); len(latencies) != 1 || latencies[0] < 20*time.Millisecond {
+		t.Errorf("got latencies %v, want one of at least the delay", latencies)
+	}
+}
+
+func TestHedgeIsDiscardedWhenOriginalWins(t *testing.T) {
+	canceled := make(chan struct{})
+	server := &hedgeServer{wait: func(r *http.Request, n int) int {
+		switch n {
+		case 1:
+			waitFor(r, 50*time.Millisecond)
+		case 2:
+			if waitFor(r, 5*time.Second) {
+				close(canceled)
+			}
+		}
+		return http.StatusOK
+	}}
+	hedger := NewHedger(HedgingConfig{Delay: 10 * time.Millisecond})
+
+	response, err := sendHedged(t, hedger, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header().Get("X-Request"); got != "1" {
+		t.Errorf("got response to request %s, want the original", got)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the hedge request was not canceled")
+	}
+	deadline := time.Now().Add(time.Second)
+	for hedger.hedgesInFlight() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := hedger.hedgesInFlight(); got != 0 {
+		t.Errorf("got %d hedges in flight, want 0", got)
+	}
+	if latencies := hedger.observed(); len(latencies) != 1 || latencies[0] < 50*time.Millisecond {
+		t.Errorf("got latencies %v, want the one of the original request", latencies)
+	}
+}
+
+func TestHedgeServesFailedRequest(t *testing.T) {
+	server := &hedgeServer{wait: func(r *http.Request, n int) int {
+		if n == 1 {
+			// the original request fails while the hedge is pending
+			waitFor(r, 50*time.Millisecond)
+			return http.StatusInternalServerError
+		}
+		waitFor(r, 100*time.Millisecond)
+		return http.StatusOK
+	}}
+	hedger := NewHedger(HedgingConfig{Delay: 10 * time.Millisecond})
+
+	response, err := sendHedged(t, hedger, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header().Get("X-Request"); got != "2" {
+		t.Errorf("got response to request %s, want the hedge", got)
+	}
+	// neither the failed request nor a hedge that outlived it is a latency of the original
+	if latencies := hedger.observed(); len(latencies) != 0 {
+		t.Errorf("got latencies %v, want none", latencies)
+	}
+}
+
+func TestHedgeReturnsErrorWhenAllRequestsFail(t *testing.T) {
+	server := &hedgeServer{wait: func(r *http.Request, n int) int {
+		if n == 1 {
+			waitFor(r, 50*time.Millisecond)
+		}
+		return http.StatusBadGateway
+	}}
+	hedger := NewHedger(HedgingConfig{Delay: 10 * time.Millisecond})
+
+	_, err := sendHedged(t, hedger, server)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusBadGateway {
+		t.Errorf("got error %v, want a 502 API error", err)
+	}
+	if got := server.requests.Load(); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+}
+
+func TestHedgeIsCappedByMaxHedges(t *testing.T) {
+	server := &hedgeServer{wait: func(r *http.Request, n int) int {
+		if n == 1 {
+			waitFor(r, 50*time.Millisecond)
+		}
+		return http.StatusOK
+	}}
+	hedger := NewHedger(HedgingConfig{Delay: 10 * time.Millisecond, MaxHedges: 1})
+
+	// another request holds the only hedge
+	if _, ok := hedger.acquire(); !ok {
+		t.Fatal("could not acquire a hedge")
+	}
+	response, err := sendHedged(t, hedger, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header().Get("X-Request"); got != "1" || server.requests.Load() != 1 {
+		t.Errorf("got response to request %s of %d, want the only one", got, server.requests.Load())
+	}
+
+	hedger.release()
+	server.requests.Store(0)
+	response, err = sendHedged(t, hedger, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header().Get("X-Request"); got != "2" {
+		t.Errorf("got response to request %s, want the hedge", got)
+	}
+}
+
+func TestHedgerDelayFollowsPercentile(t *testing.T) {
+	hedger := NewHedger(HedgingConfig{Delay: time.Second, Percentile: 0.9, MinSamples: 10, Samples: 10})
+	for i := 1; i < 10; i++ {
+		hedger.observe(time.Duration(i) * time.Millisecond)
+	}
+	if got := hedger.delay(); got != time.Second {
+		t.Errorf("got delay %v before MinSamples, want %v", got, time.Second)
+	}
+	hedger.observe(10 * time.Millisecond)
+	if got := hedger.delay(); got != 9*time.Millisecond {
+		t.Errorf("got delay %v, want %v", got, 9*time.Millisecond)
+	}
+	// the oldest latency is replaced once Samples are kept
+	for range 5 {
+		hedger.observe(100 * time.Millisecond)
+	}
+	if got := hedger.delay(); got != 100*time.Millisecond {
+		t.Errorf("got delay %v, want %v", got, 100*time.Millisecond)
+	}
+}
+
+func TestHedgeObservesFastRequests(t *testing.T) {
+	server := &hedgeServer{wait: func(*http.Request, int) int { return http.StatusOK }}
+	hedger := NewHedger(HedgingConfig{Delay: time.Second})
+
+	if _, err := sendHedged(t, hedger, server); err != nil {
+		t.Fatal(err)
+	}
+	if latencies := hedger.observed(); len(latencies) != 1 || latencies[0] >= time.Second {
+		t.Errorf("got latencies %v, want one below the delay", latencies)
+	}
+	if got := server.requests.Load(); got != 1 {
+		t.Errorf("server received %d requests, want 1", got)
+	}
+}
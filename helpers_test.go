@@ -0,0 +1,79 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newTestConfig starts a server with handler that is closed when the test ends and returns
+// the config of a client sending its requests there.
+func newTestConfig(t *testing.T, handler http.Handler) ClientConfig {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config := DefaultConfig("test")
+	config.BaseURL = server.URL
+	return config
+}
+
+// newTestClient starts a server with handler that is closed when the test ends and returns
+// a client sending its requests there. configure, if not nil, adjusts the config of the client.
+func newTestClient(t *testing.T, handler http.Handler, configure func(*ClientConfig)) *Client {
+	t.Helper()
+	config := newTestConfig(t, handler)
+	if configure != nil {
+		configure(&config)
+	}
+	return NewClientWithConfig(config)
+}
+
+// chatCompletionServer answers chat completion requests with a completion without choices
+// and records the requests it receives.
+type chatCompletionServer struct {
+	// header is sent with every response.
+	header map[string]string
+	// status, if set, returns the status code of the response to a request. An error status
+	// is sent with an error body.
+	status func(r *http.Request, body []byte) int
+
+	mu       sync.Mutex
+	received []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (s *chatCompletionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.received = append(s.received, receivedRequest{header: r.Header.Clone(), body: body})
+	s.mu.Unlock()
+
+	for k, v := range s.header {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	status := http.StatusOK
+	if s.status != nil {
+		status = s.status(r, body)
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{"error":{"message":"`+http.StatusText(status)+`","type":"server_error"}}`)
+		return
+	}
+	_, _ = io.WriteString(w, `{"id":"chatcmpl-123","object":"chat.completion","choices":[]}`)
+}
+
+// requests returns the requests received so far.
+func (s *chatCompletionServer) requests() []receivedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedRequest(nil), s.received...)
+}
@@ -76,13 +76,16 @@ type MessageFilesList struct {
 // CreateMessage creates a new message.
 func (c *Client) CreateMessage(ctx context.Context, threadID string, request MessageRequest) (msg Message, err error) {
 	urlSuffix := fmt.Sprintf("/threads/%s/%s", threadID, messagesSuffix)
-	req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix), withBody(request))
-	if err != nil {
+	call := &Call{Operation: "CreateMessage", Method: http.MethodPost, Path: urlSuffix, Request: request}
+	return invoke(ctx, c, call, func(ctx context.Context, request MessageRequest, call *Call) (msg Message, err error) {
+		req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix), withBody(request))
+		if err != nil {
+			return
+		}
+
+		err = c.sendRequest(req, &msg, call.RetryOptions...)
 		return
-	}
-
-	err = c.sendRequest(req, &msg)
-	return
+	})
 }
 
 // ListMessage fetches all messages in the thread.
@@ -111,13 +114,16 @@ func (c *Client) ListMessage(ctx context.Context, threadID string,
 	}
 
 	urlSuffix := fmt.Sprintf("/threads/%s/%s%s", threadID, messagesSuffix, encodedValues)
-	req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
-	if err != nil {
+	call := &Call{Operation: "ListMessage", Method: http.MethodGet, Path: urlSuffix}
+	return invoke(ctx, c, call, func(ctx context.Context, _ any, call *Call) (messages MessagesList, err error) {
+		req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
+		if err != nil {
+			return
+		}
+
+		err = c.sendRequest(req, &messages, call.RetryOptions...)
 		return
-	}
-
-	err = c.sendRequest(req, &messages)
-	return
+	})
 }
 
 // RetrieveMessage retrieves a Message.
@@ -126,13 +132,16 @@ func (c *Client) RetrieveMessage(
 	threadID, messageID string,
 ) (msg Message, err error) {
 	urlSuffix := fmt.Sprintf("/threads/%s/%s/%s", threadID, messagesSuffix, messageID)
-	req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
-	if err != nil {
+	call := &Call{Operation: "RetrieveMessage", Method: http.MethodGet, Path: urlSuffix}
+	return invoke(ctx, c, call, func(ctx context.Context, _ any, call *Call) (msg Message, err error) {
+		req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
+		if err != nil {
+			return
+		}
+
+		err = c.sendRequest(req, &msg, call.RetryOptions...)
 		return
-	}
-
-	err = c.sendRequest(req, &msg)
-	return
+	})
 }
 
 // ModifyMessage modifies a message.
@@ -142,14 +151,17 @@ func (c *Client) ModifyMessage(
 	metadata map[string]any,
 ) (msg Message, err error) {
 	urlSuffix := fmt.Sprintf("/threads/%s/%s/%s", threadID, messagesSuffix, messageID)
-	req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix),
-		withBody(metadata))
-	if err != nil {
+	call := &Call{Operation: "ModifyMessage", Method: http.MethodPost, Path: urlSuffix, Request: metadata}
+	return invoke(ctx, c, call, func(ctx context.Context, metadata map[string]any, call *Call) (msg Message, err error) {
+		req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix),
+			withBody(metadata))
+		if err != nil {
+			return
+		}
+
+		err = c.sendRequest(req, &msg, call.RetryOptions...)
 		return
-	}
-
-	err = c.sendRequest(req, &msg)
-	return
+	})
 }
 
 // RetrieveMessageFile fetches a message file.
@@ -158,13 +170,16 @@ func (c *Client) RetrieveMessageFile(
 	threadID, messageID, fileID string,
 ) (file MessageFile, err error) {
 	urlSuffix := fmt.Sprintf("/threads/%s/%s/%s/files/%s", threadID, messagesSuffix, messageID, fileID)
-	req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
-	if err != nil {
+	call := &Call{Operation: "RetrieveMessageFile", Method: http.MethodGet, Path: urlSuffix}
+	return invoke(ctx, c, call, func(ctx context.Context, _ any, call *Call) (file MessageFile, err error) {
+		req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
+		if err != nil {
+			return
+		}
+
+		err = c.sendRequest(req, &file, call.RetryOptions...)
 		return
-	}
-
-	err = c.sendRequest(req, &file)
-	return
+	})
 }
 
 // ListMessageFiles fetches all files attached to a message.
@@ -173,11 +188,14 @@ func (c *Client) ListMessageFiles(
 	threadID, messageID string,
 ) (files MessageFilesList, err error) {
 	urlSuffix := fmt.Sprintf("/threads/%s/%s/%s/files", threadID, messagesSuffix, messageID)
-	req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
-	if err != nil {
+	call := &Call{Operation: "ListMessageFiles", Method: http.MethodGet, Path: urlSuffix}
+	return invoke(ctx, c, call, func(ctx context.Context, _ any, call *Call) (files MessageFilesList, err error) {
+		req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
+		if err != nil {
+			return
+		}
+
+		err = c.sendRequest(req, &files, call.RetryOptions...)
 		return
-	}
-
-	err = c.sendRequest(req, &files)
-	return
+	})
 }
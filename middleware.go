@@ -0,0 +1,82 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Call describes a call of a Client method as it passes through the middleware chain.
+type Call struct {
+	// Operation is the name of the Client method, e.g. "CreateChatCompletion".
+	Operation string
+	// Method and Path are the HTTP method and the path of the endpoint relative to the base URL.
+	// They are informational, changes are ignored.
+	Method string
+	Path   string
+	// Model is the model of the request as passed by the caller, if it has one. It is
+	// informational, a middleware changes the model by replacing Request.
+	Model string
+	// Request is the typed request, e.g. a ChatCompletionRequest, or nil for endpoints
+	// without a request body. A middleware may replace it with a value of the same type.
+	Request any
+	// Headers are the extra headers sent with the request, a copy of those passed by the
+	// caller. A middleware may modify them.
+	Headers map[string]string
+	// RetryOptions are a copy of the retry options passed by the caller. A middleware may
+	// modify them.
+	RetryOptions []RetryOptions
+}
+
+// CallHandler performs a call and returns its typed response, e.g. a ChatCompletionResponse
+// or a *ChatCompletionStream.
+type CallHandler func(ctx context.Context, call *Call) (any, error)
+
+// Middleware wraps the handling of every call of a Client, see ClientConfig.Middleware. It
+// may inspect the call and modify its Request, Headers and RetryOptions before passing it on
+// to next, inspect the response or error returned by next, or short-circuit by returning a
+// response of the type the Client method returns without calling next.
+type Middleware func(next CallHandler) CallHandler
+
+// invoke passes a call through the middleware chain of the client, at the end of which do
+// performs it.
+func invoke[Req, Resp any](
+	ctx context.Context,
+	c *Client,
+	call *Call,
+	do func(ctx context.Context, request Req, call *Call) (Resp, error),
+) (Resp, error) {
+	if len(c.config.Middleware) == 0 {
+		request, _ := call.Request.(Req)
+		return do(ctx, request, call)
+	}
+
+	// the caller's headers and options must not change when a middleware modifies them
+	call.Headers = maps.Clone(call.Headers)
+	if call.Headers == nil {
+		call.Headers = map[string]string{}
+	}
+	call.RetryOptions = slices.Clone(call.RetryOptions)
+
+	handler := func(ctx context.Context, call *Call) (any, error) {
+		request, ok := call.Request.(Req)
+		if !ok && call.Request != nil {
+			return nil, fmt.Errorf("middleware passed a %T request to %s, expected %T", call.Request, call.Operation, request)
+		}
+		return do(ctx, request, call)
+	}
+	for i := len(c.config.Middleware) - 1; i >= 0; i-- {
+		handler = c.config.Middleware[i](handler)
+	}
+
+	resp, err := handler(ctx, call)
+	if resp == nil {
+		return *new(Resp), err
+	}
+	typed, ok := resp.(Resp)
+	if !ok {
+		return typed, fmt.Errorf("middleware returned a %T response from %s, expected %T", resp, call.Operation, typed)
+	}
+	return typed, err
+}
@@ -107,11 +107,15 @@ type ModelsList struct {
 // ListModels Lists the currently available models,
 // and provides basic information about each model such as the model id and parent.
 func (c *Client) ListModels(ctx context.Context) (models ModelsList, err error) {
-	req, err := c.newRequest(ctx, http.MethodGet, c.fullURL("/models"))
-	if err != nil {
-		return
-	}
+	urlSuffix := "/models"
+	call := &Call{Operation: "ListModels", Method: http.MethodGet, Path: urlSuffix}
+	return invoke(ctx, c, call, func(ctx context.Context, _ any, call *Call) (models ModelsList, err error) {
+		req, err := c.newRequest(ctx, http.MethodGet, c.fullURL(urlSuffix))
+		if err != nil {
+			return
+		}
 
-	err = c.sendRequest(req, &models)
-	return
+		err = c.sendRequest(req, &models, call.RetryOptions...)
+		return
+	})
 }
@@ -0,0 +1,193 @@
+package openai
+
+import (
+	"encoding/json"
+)
+
+// partialJSON incrementally scans a JSON document that arrives in fragments. It reports the
+// members of the top-level object as soon as their values are complete, and it can decode a
+// best-effort value from an incomplete document.
+type partialJSON struct {
+	buf   []byte
+	stack []byte
+
+	inString    bool
+	escape      bool
+	stringIsKey bool
+	inScalar    bool
+	lastSig     byte
+
+	// checkpoint is the end of the last complete value, together with the containers that
+	// were open at that point.
+	checkpoint      int
+	checkpointStack string
+
+	// member of the top-level object being scanned
+	key        string
+	hasKey     bool
+	keyStart   int
+	valueStart int
+}
+
+// partialJSONMember is a member of the top-level object whose value is complete.
+type partialJSONMember struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// Write scans the next fragment and returns the members of the top-level object that were
+// completed by it.
+func (p *partialJSON) Write(fragment string) []partialJSONMember {
+	var members []partialJSONMember
+	for i := 0; i < len(fragment); i++ {
+		c := fragment[i]
+		pos := len(p.buf)
+		p.buf = append(p.buf, c)
+
+		if p.inString {
+			switch {
+			case p.escape:
+				p.escape = false
+			case c == '\\':
+				p.escape = true
+			case c == '"':
+				p.inString = false
+				p.lastSig = c
+				if !p.stringIsKey {
+					members = p.valueDone(pos+1, members)
+				} else if p.atTopLevel() {
+					p.key, p.hasKey = unquoteKey(p.buf[p.keyStart : pos+1])
+				}
+			}
+			continue
+		}
+
+		if p.inScalar && isJSONDelimiter(c) {
+			p.inScalar = false
+			members = p.valueDone(pos, members)
+		}
+
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '"':
+			p.inString = true
+			p.stringIsKey = len(p.stack) > 0 && p.stack[len(p.stack)-1] == '{' && (p.lastSig == '{' || p.lastSig == ',')
+			if p.atTopLevel() {
+				if p.stringIsKey {
+					p.keyStart = pos
+				} else {
+					p.valueStart = pos
+				}
+			}
+		case '{', '[':
+			if p.atTopLevel() {
+				p.valueStart = pos
+			}
+			p.stack = append(p.stack, c)
+			p.setCheckpoint(pos + 1)
+		case '}', ']':
+			if len(p.stack) > 0 {
+				p.stack = p.stack[:len(p.stack)-1]
+			}
+			members = p.valueDone(pos+1, members)
+		case ':', ',':
+		default:
+			if !p.inScalar {
+				p.inScalar = true
+				if p.atTopLevel() {
+					p.valueStart = pos
+				}
+			}
+		}
+		p.lastSig = c
+	}
+	return members
+}
+
+// Value decodes the document scanned so far. Incomplete strings are closed, open
+// containers are closed and incomplete trailing members are dropped.
+func (p *partialJSON) Value() (any, bool) {
+	var v any
+	if !p.inString || !p.stringIsKey {
+		candidate := append([]byte(nil), p.buf...)
+		if p.inString {
+			candidate = append(trimIncompleteEscape(candidate, p.escape), '"')
+		}
+		candidate = closeJSON(candidate, string(p.stack))
+		if json.Unmarshal(candidate, &v) == nil {
+			return v, true
+		}
+	}
+
+	if p.checkpoint == 0 {
+		return nil, false
+	}
+	candidate := closeJSON(append([]byte(nil), p.buf[:p.checkpoint]...), p.checkpointStack)
+	if json.Unmarshal(candidate, &v) == nil {
+		return v, true
+	}
+	return nil, false
+}
+
+func (p *partialJSON) atTopLevel() bool {
+	return len(p.stack) == 1 && p.stack[0] == '{'
+}
+
+func (p *partialJSON) setCheckpoint(end int) {
+	p.checkpoint = end
+	p.checkpointStack = string(p.stack)
+}
+
+func (p *partialJSON) valueDone(end int, members []partialJSONMember) []partialJSONMember {
+	p.setCheckpoint(end)
+	if p.atTopLevel() && p.hasKey {
+		members = append(members, partialJSONMember{
+			Key:   p.key,
+			Value: json.RawMessage(append([]byte(nil), p.buf[p.valueStart:end]...)),
+		})
+		p.key, p.hasKey = "", false
+	}
+	return members
+}
+
+func isJSONDelimiter(c byte) bool {
+	switch c {
+	case ',', '}', ']', ' ', '\t', '\n', '\r':
+		return true
+	}
+	return false
+}
+
+func unquoteKey(quoted []byte) (string, bool) {
+	var key string
+	if err := json.Unmarshal(quoted, &key); err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// trimIncompleteEscape removes an escape sequence that was cut off at the end of a string.
+func trimIncompleteEscape(b []byte, escape bool) []byte {
+	if escape {
+		return b[:len(b)-1]
+	}
+	// a unicode escape needs four hex digits
+	for n := 2; n <= 5 && n <= len(b); n++ {
+		if b[len(b)-n] == '\\' && b[len(b)-n+1] == 'u' {
+			return b[:len(b)-n]
+		}
+	}
+	return b
+}
+
+func closeJSON(b []byte, stack string) []byte {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == '{' {
+			b = append(b, '}')
+		} else {
+			b = append(b, ']')
+		}
+	}
+	return b
+}
@@ -0,0 +1,159 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter delays requests on the client side to stay within the request and token rate
+// limits of the API, see ClientConfig.RateLimiter. The limits are learned and continuously
+// corrected from the x-ratelimit-* headers of every response. A RateLimiter may be shared by
+// clients using the same API key.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests tokenBucket
+	tokens   tokenBucket
+}
+
+// NewRateLimiter creates a rate limiter seeded with the given limits per minute, which are
+// replaced by the limits the API reports. A limit of 0 is not enforced until it is reported.
+func NewRateLimiter(requestsPerMinute, tokensPerMinute int) *RateLimiter {
+	l := &RateLimiter{}
+	now := time.Now()
+	l.requests.seed(requestsPerMinute, now)
+	l.tokens.seed(tokensPerMinute, now)
+	return l
+}
+
+// tokenBucket holds up to limit units and refills at rate units per second. available goes
+// negative when units are reserved ahead of time, which makes later reservations wait longer.
+type tokenBucket struct {
+	limit     float64
+	available float64
+	rate      float64
+	last      time.Time
+}
+
+func (b *tokenBucket) seed(perMinute int, now time.Time) {
+	if perMinute <= 0 {
+		return
+	}
+	b.limit = float64(perMinute)
+	b.available = b.limit
+	b.rate = b.limit / time.Minute.Seconds()
+	b.last = now
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 && now.After(b.last) {
+		b.available = min(b.limit, b.available+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// reserve takes n units and returns how long to wait until they are available.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 || n <= 0 {
+		return 0
+	}
+	b.refill(now)
+	// a request larger than the bucket can only wait for a full bucket
+	n = min(n, b.limit)
+	b.available -= n
+	if b.available >= 0 {
+		return 0
+	}
+	return time.Duration(-b.available / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) cancel(n float64) {
+	if b.rate > 0 && n > 0 {
+		b.available = min(b.limit, b.available+min(n, b.limit))
+	}
+}
+
+// update corrects the bucket from the limit, the remaining units and the time until the
+// bucket is full again as reported by the API.
+func (b *tokenBucket) update(limit, remaining int, reset time.Duration, now time.Time) {
+	if limit <= 0 {
+		return
+	}
+	learned := b.rate > 0
+	b.refill(now)
+
+	b.limit = float64(limit)
+	b.rate = b.limit / time.Minute.Seconds()
+	if reset > 0 && limit > remaining {
+		b.rate = float64(limit-remaining) / reset.Seconds()
+	}
+	// units reserved by requests that are still in flight are not in remaining yet, so only
+	// ever correct downwards, the refill takes care of the rest
+	if learned {
+		b.available = min(b.available, float64(remaining))
+	} else {
+		b.available = float64(remaining)
+	}
+}
+
+// wait blocks until a request estimated to use the given number of tokens may be sent.
+func (l *RateLimiter) wait(ctx context.Context, tokens int) error {
+	l.mu.Lock()
+	now := time.Now()
+	delay := max(l.requests.reserve(1, now), l.tokens.reserve(float64(tokens), now))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.requests.cancel(1)
+		l.tokens.cancel(float64(tokens))
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// update corrects the limits from the headers of a response.
+func (l *RateLimiter) update(header http.Header) {
+	limits := newRateLimitHeaders(header)
+	remainingRequests, requestsErr := strconv.Atoi(header.Get("x-ratelimit-remaining-requests"))
+	remainingTokens, tokensErr := strconv.Atoi(header.Get("x-ratelimit-remaining-tokens"))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if requestsErr == nil {
+		l.requests.update(limits.LimitRequests, remainingRequests, limits.ResetRequests.Duration(), now)
+	}
+	if tokensErr == nil {
+		l.tokens.update(limits.LimitTokens, remainingTokens, limits.ResetTokens.Duration(), now)
+	}
+}
+
+// estimateTokens estimates the tokens a request counts against the token rate limit: about
+// four bytes of the body per prompt token plus the maximum number of completion tokens.
+func estimateTokens(body []byte) int {
+	if len(body) == 0 {
+		return 0
+	}
+
+	var request struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+		N                   int `json:"n"`
+	}
+	_ = json.Unmarshal(body, &request)
+	completion := max(request.MaxTokens, request.MaxCompletionTokens) * max(request.N, 1)
+	return len(body)/4 + completion
+}
@@ -0,0 +1,128 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBlocksUntilReset(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{
+			name: "requests",
+			headers: map[string]string{
+				"x-ratelimit-limit-requests":     "1",
+				"x-ratelimit-remaining-requests": "0",
+				"x-ratelimit-reset-requests":     "300ms",
+			},
+		},
+		{
+			name: "tokens",
+			headers: map[string]string{
+				"x-ratelimit-limit-tokens":     "1000",
+				"x-ratelimit-remaining-tokens": "0",
+				"x-ratelimit-reset-tokens":     "300ms",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &chatCompletionServer{header: tt.headers}
+			// no limits are known until the first response reports them
+			client := newTestClient(t, server, func(config *ClientConfig) {
+				config.RateLimiter = NewRateLimiter(0, 0)
+			})
+			// the request needs the whole token budget, so it waits for the bucket to be full
+			request := ChatCompletionRequest{Model: GPT4o, MaxTokens: 1000}
+
+			start := time.Now()
+			if _, err := client.CreateChatCompletion(context.Background(), request, nil); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+				t.Fatalf("first request was delayed by %v", elapsed)
+			}
+
+			start = time.Now()
+			if _, err := client.CreateChatCompletion(context.Background(), request, nil); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+				t.Errorf("second request was sent after %v, before the limit reset", elapsed)
+			}
+			if got := len(server.requests()); got != 2 {
+				t.Errorf("server received %d requests, want 2", got)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSeededLimit(t *testing.T) {
+	server := &chatCompletionServer{}
+	// one request per minute allows the first request only
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.RateLimiter = NewRateLimiter(1, 0)
+	})
+
+	if _, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.CreateChatCompletion(ctx, ChatCompletionRequest{Model: GPT4o}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := len(server.requests()); got != 1 {
+		t.Errorf("server received %d requests, want 1", got)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	server := &chatCompletionServer{header: map[string]string{
+		"x-ratelimit-limit-requests":     "10",
+		"x-ratelimit-remaining-requests": "0",
+		"x-ratelimit-reset-requests":     "1m",
+	}}
+	limiter := NewRateLimiter(0, 0)
+	client := newTestClient(t, server, func(config *ClientConfig) {
+		config.RateLimiter = limiter
+	})
+	if _, err := client.CreateChatCompletion(context.Background(), ChatCompletionRequest{Model: GPT4o}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.CreateChatCompletion(ctx, ChatCompletionRequest{Model: GPT4o}, nil)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request kept waiting after its context was canceled")
+	}
+	if got := len(server.requests()); got != 1 {
+		t.Errorf("server received %d requests, want 1", got)
+	}
+
+	// the canceled request gives back the capacity it reserved
+	limiter.mu.Lock()
+	available := limiter.requests.available
+	limiter.mu.Unlock()
+	if available < -0.1 {
+		t.Errorf("canceled request still holds capacity, %v requests available", available)
+	}
+}
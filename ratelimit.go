@@ -22,9 +22,14 @@ func (r ResetTime) String() string {
 	return string(r)
 }
 
-func (r ResetTime) Time() time.Time {
+// Duration returns the time until the rate limit resets, or 0 if it is not known.
+func (r ResetTime) Duration() time.Duration {
 	d, _ := time.ParseDuration(string(r))
-	return time.Now().Add(d)
+	return d
+}
+
+func (r ResetTime) Time() time.Time {
+	return time.Now().Add(r.Duration())
 }
 
 func newRateLimitHeaders(h http.Header) RateLimitHeaders {
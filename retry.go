@@ -0,0 +1,310 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	retryBaseDelay = time.Millisecond * 200
+
+	// defaultMaxRetryDelay caps the retry delays if RetryOptions.MaxRetryDelay is not set.
+	defaultMaxRetryDelay = time.Minute
+)
+
+var ErrRetryAfterDeadline = errors.New("the delay before the next retry exceeds the context deadline")
+
+// Backoff computes the delay before a retry. Implementations must be safe for concurrent use.
+type Backoff interface {
+	// Delay returns the delay before the next attempt after attempt, counted from 0, failed.
+	// previous is the delay that was waited before attempt, 0 for the first one.
+	Delay(attempt int, previous time.Duration) time.Duration
+}
+
+// ExponentialBackoff doubles the maximum delay with every attempt, starting at Base, and
+// waits a random delay up to it ("full jitter"). Max caps the maximum delay if it is set.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b ExponentialBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	ceiling := b.Base
+	for range attempt {
+		if ceiling > math.MaxInt64/2 || (b.Max > 0 && ceiling >= b.Max) {
+			break
+		}
+		ceiling *= 2
+	}
+	return randomDelay(0, capDelay(ceiling, b.Max))
+}
+
+// DecorrelatedJitterBackoff waits a random delay between Base and three times the previous
+// delay. Max caps the delay if it is set.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Delay(_ int, previous time.Duration) time.Duration {
+	return capDelay(randomDelay(b.Base, max(previous*3, b.Base)), b.Max)
+}
+
+// ConstantBackoff always waits the same delay. ConstantBackoff(0) retries immediately,
+// which is useful in tests.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Delay(int, time.Duration) time.Duration {
+	return time.Duration(b)
+}
+
+// FibonacciBackoff waits Base times the Fibonacci number of the attempt: Base, Base, 2·Base,
+// 3·Base, 5·Base and so on. Max caps the delay if it is set.
+type FibonacciBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b FibonacciBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	a, c := time.Duration(1), time.Duration(1)
+	for range attempt {
+		a, c = c, a+c
+		if b.Max > 0 && b.Base*a >= b.Max {
+			return b.Max
+		}
+	}
+	return capDelay(b.Base*a, b.Max)
+}
+
+// defaultBackoff waits 200ms·2^attempt plus up to 200ms of jitter.
+type defaultBackoff struct{}
+
+func (defaultBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	delay := retryBaseDelay * time.Duration(1<<attempt)
+	jitter := time.Duration(rand.Int63n(int64(retryBaseDelay)))
+	return delay + jitter
+}
+
+// retryDelay returns the delay before retrying after the given attempt failed with resp,
+// which may be nil. A delay requested by the server takes precedence over the backoff.
+// Both are capped by MaxRetryDelay.
+func (r *RetryOptions) retryDelay(attempt int, previous time.Duration, resp *http.Response) time.Duration {
+	maxDelay := r.MaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxRetryDelay
+	}
+	if hint := retryAfter(resp); hint > 0 {
+		return min(hint, maxDelay)
+	}
+
+	backoff := r.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff{}
+	}
+	return min(max(backoff.Delay(attempt, previous), 0), maxDelay)
+}
+
+// exceedsElapsed reports whether retrying after delay would exceed MaxRetryElapsed for
+// a request whose first attempt started at start.
+func (r *RetryOptions) exceedsElapsed(start time.Time, delay time.Duration) bool {
+	return r.MaxRetryElapsed > 0 && time.Since(start)+delay > r.MaxRetryElapsed
+}
+
+func randomDelay(low, high time.Duration) time.Duration {
+	if high <= low {
+		return low
+	}
+	return low + time.Duration(rand.Int63n(int64(high-low)))
+}
+
+func capDelay(delay, maxDelay time.Duration) time.Duration {
+	if maxDelay > 0 && (delay > maxDelay || delay < 0) {
+		return maxDelay
+	}
+	return delay
+}
+
+// retryAfter returns the delay a failed response asks for before retrying, or 0. It honors
+// retry-after-ms, Retry-After in seconds or as a date, and for 429 responses the reset time
+// of the exhausted rate limit.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil || !isFailureStatusCode(resp) {
+		return 0
+	}
+
+	if ms, err := strconv.ParseFloat(resp.Header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(time.Until(date), 0)
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+	// wait for the limit that ran out, or for both if the response doesn't tell which one it was
+	requests := resp.Header.Get("x-ratelimit-remaining-requests") == "0"
+	tokens := resp.Header.Get("x-ratelimit-remaining-tokens") == "0"
+	limits := newRateLimitHeaders(resp.Header)
+	var delay time.Duration
+	if requests || !tokens {
+		delay = max(delay, limits.ResetRequests.Duration())
+	}
+	if tokens || !requests {
+		delay = max(delay, limits.ResetTokens.Duration())
+	}
+	return delay
+}
+
+// exceedsDeadline reports whether waiting for delay would pass the deadline of ctx.
+func exceedsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < delay
+}
+
+// RetryPredicate decides whether a failed attempt, counted from 0, is retried. resp is nil
+// if the request failed with the transport error err. Otherwise respErr is the *APIError or
+// *RequestError decoded from the failure response.
+type RetryPredicate func(attempt int, resp *http.Response, respErr error, err error) bool
+
+// DefaultRetryPredicate retries what the OpenAI API documents as transient: rate limits
+// except for exhausted quotas, server errors, request timeouts and conflicts, and dropped or
+// timed out connections. A x-should-retry header sent by the server takes precedence.
+// Canceled requests are never retried.
+func DefaultRetryPredicate(_ int, resp *http.Response, respErr error, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if resp == nil {
+		return isTransientNetworkError(err)
+	}
+
+	switch resp.Header.Get("x-should-retry") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(respErr, &apiErr) {
+		if apiErr.Type == "insufficient_quota" || apiErr.Code == "insufficient_quota" {
+			return false
+		}
+		if apiErr.Type == "server_error" || apiErr.Code == "server_error" {
+			return true
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusConflict,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= http.StatusInternalServerError:
+		return true
+	}
+	return false
+}
+
+// isTransientNetworkError reports whether a request failed because its connection timed out
+// or was dropped. Other transport errors, e.g. a refused connection, a host that does not
+// resolve or a failed TLS handshake, would most likely fail the same way again.
+func isTransientNetworkError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// shouldRetry reports whether a failed attempt is retried. Requests rejected by an open
+// circuit never are. Without a RetryPredicate, failure responses are retried according to
+// RetryAboveCode and RetryCodes and transport errors always are.
+func (r *RetryOptions) shouldRetry(attempt int, resp *http.Response, respErr error, err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if r.RetryPredicate != nil {
+		return r.RetryPredicate(attempt, resp, respErr, err)
+	}
+	return resp == nil || r.canRetry(resp.StatusCode)
+}
+
+// RetryAttempt describes a failed attempt of a request.
+type RetryAttempt struct {
+	// StatusCode and Status are those of the failure response, empty if no response was received.
+	StatusCode int
+	Status     string
+	// ResponseErr is the *APIError or *RequestError decoded from the failure response.
+	ResponseErr error
+	// Err is the transport error, or the error the first chunk of a stream failed with.
+	Err error
+	// Delay is the time waited before the attempt and Duration the time the attempt took.
+	Delay    time.Duration
+	Duration time.Duration
+}
+
+// RetryExhaustedError is returned when a request failed and is not retried any further,
+// because the retries are used up, the failure is not retriable or the context is done.
+// It unwraps to the error of the last attempt, which is an *APIError or *RequestError if
+// a failure response was received, and to the context error.
+type RetryExhaustedError struct {
+	Attempts []RetryAttempt
+	// Err is the reason retrying stopped early, such as the context error or ErrRetryAfterDeadline.
+	Err error
+
+	msg string
+}
+
+func (e *RetryExhaustedError) Error() string {
+	return e.msg
+}
+
+func (e *RetryExhaustedError) Unwrap() []error {
+	var errs []error
+	if len(e.Attempts) > 0 {
+		last := e.Attempts[len(e.Attempts)-1]
+		if last.ResponseErr != nil {
+			errs = append(errs, last.ResponseErr)
+		} else if last.Err != nil {
+			errs = append(errs, last.Err)
+		}
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+func newRetryAttempt(resp *http.Response, respErr, err error, delay, duration time.Duration) RetryAttempt {
+	attempt := RetryAttempt{
+		ResponseErr: respErr,
+		Err:         err,
+		Delay:       delay,
+		Duration:    duration,
+	}
+	if resp != nil {
+		attempt.StatusCode = resp.StatusCode
+		attempt.Status = resp.Status
+	}
+	return attempt
+}
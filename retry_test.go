@@ -0,0 +1,51 @@
+package openai
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestDefaultRetryPredicateTransportErrors(t *testing.T) {
+	opError := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://api.openai.com/v1/chat/completions", Err: &net.OpError{Op: "read", Net: "tcp", Err: err}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection reset", err: opError(os.NewSyscallError("read", syscall.ECONNRESET)), want: true},
+		{name: "connection aborted", err: opError(os.NewSyscallError("read", syscall.ECONNABORTED)), want: true},
+		{name: "broken pipe", err: opError(os.NewSyscallError("write", syscall.EPIPE)), want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "timeout", err: opError(os.ErrDeadlineExceeded), want: true},
+		{name: "connection refused", err: opError(os.NewSyscallError("connect", syscall.ECONNREFUSED)), want: false},
+		{name: "unknown host", err: opError(&net.DNSError{Err: "no such host", Name: "api.example.com", IsNotFound: true}), want: false},
+		{name: "temporary DNS failure", err: opError(&net.DNSError{Err: "server misbehaving", Name: "api.example.com", IsTemporary: true}), want: true},
+		{name: "DNS timeout", err: opError(&net.DNSError{Err: "i/o timeout", Name: "api.example.com", IsTimeout: true}), want: true},
+		{name: "TLS failure", err: opError(x509.UnknownAuthorityError{}), want: false},
+		{name: "canceled", err: opError(context.Canceled), want: false},
+		{name: "other error", err: errors.New("unsupported protocol scheme"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultRetryPredicate(0, nil, nil, tt.err); got != tt.want {
+				t.Errorf("DefaultRetryPredicate(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	// responses are not judged by their transport error
+	if !DefaultRetryPredicate(0, &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}}, nil, nil) {
+		t.Error("a 502 response is not retried")
+	}
+}
@@ -0,0 +1,198 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const (
+	runsSuffix = "runs"
+)
+
+type RunStatus string
+
+const (
+	RunStatusQueued         RunStatus = "queued"
+	RunStatusInProgress     RunStatus = "in_progress"
+	RunStatusRequiresAction RunStatus = "requires_action"
+	RunStatusCancelling     RunStatus = "cancelling"
+	RunStatusCancelled      RunStatus = "cancelled"
+	RunStatusFailed         RunStatus = "failed"
+	RunStatusCompleted      RunStatus = "completed"
+	RunStatusIncomplete     RunStatus = "incomplete"
+	RunStatusExpired        RunStatus = "expired"
+)
+
+type Run struct {
+	ID             string          `json:"id"`
+	Object         string          `json:"object"`
+	CreatedAt      int64           `json:"created_at"`
+	ThreadID       string          `json:"thread_id"`
+	AssistantID    string          `json:"assistant_id"`
+	Status         RunStatus       `json:"status"`
+	RequiredAction *RequiredAction `json:"required_action,omitempty"`
+	LastError      *RunLastError   `json:"last_error,omitempty"`
+	ExpiresAt      *int64          `json:"expires_at,omitempty"`
+	StartedAt      *int64          `json:"started_at,omitempty"`
+	CancelledAt    *int64          `json:"cancelled_at,omitempty"`
+	FailedAt       *int64          `json:"failed_at,omitempty"`
+	CompletedAt    *int64          `json:"completed_at,omitempty"`
+	Model          string          `json:"model"`
+	Instructions   string          `json:"instructions,omitempty"`
+	Tools          []Tool          `json:"tools"`
+	Metadata       map[string]any  `json:"metadata"`
+	Usage          *Usage          `json:"usage,omitempty"`
+
+	httpHeader
+}
+
+type RunLastError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// RequiredAction is set on a run with the status requires_action.
+type RequiredAction struct {
+	Type              string             `json:"type"`
+	SubmitToolOutputs *SubmitToolOutputs `json:"submit_tool_outputs,omitempty"`
+}
+
+type SubmitToolOutputs struct {
+	ToolCalls []ToolCall `json:"tool_calls"`
+}
+
+type RunRequest struct {
+	AssistantID            string         `json:"assistant_id"`
+	Model                  string         `json:"model,omitempty"`
+	Instructions           string         `json:"instructions,omitempty"`
+	AdditionalInstructions string         `json:"additional_instructions,omitempty"`
+	Tools                  []Tool         `json:"tools,omitempty"`
+	Metadata               map[string]any `json:"metadata,omitempty"`
+	Temperature            *float32       `json:"temperature,omitempty"`
+	Stream                 bool           `json:"stream,omitempty"`
+}
+
+type SubmitToolOutputsRequest struct {
+	ToolOutputs []ToolOutput `json:"tool_outputs"`
+	Stream      bool         `json:"stream,omitempty"`
+}
+
+type ToolOutput struct {
+	ToolCallID string `json:"tool_call_id"`
+	Output     string `json:"output"`
+}
+
+type RunStepType string
+
+const (
+	RunStepTypeMessageCreation RunStepType = "message_creation"
+	RunStepTypeToolCalls       RunStepType = "tool_calls"
+)
+
+type RunStep struct {
+	ID          string         `json:"id"`
+	Object      string         `json:"object"`
+	CreatedAt   int64          `json:"created_at"`
+	AssistantID string         `json:"assistant_id"`
+	ThreadID    string         `json:"thread_id"`
+	RunID       string         `json:"run_id"`
+	Type        RunStepType    `json:"type"`
+	Status      RunStatus      `json:"status"`
+	StepDetails RunStepDetails `json:"step_details"`
+	LastError   *RunLastError  `json:"last_error,omitempty"`
+	CompletedAt *int64         `json:"completed_at,omitempty"`
+	Metadata    map[string]any `json:"metadata"`
+	Usage       *Usage         `json:"usage,omitempty"`
+}
+
+type RunStepDetails struct {
+	Type            RunStepType             `json:"type"`
+	MessageCreation *RunStepMessageCreation `json:"message_creation,omitempty"`
+	ToolCalls       []RunStepToolCall       `json:"tool_calls,omitempty"`
+}
+
+type RunStepMessageCreation struct {
+	MessageID string `json:"message_id"`
+}
+
+// RunStepToolCall is a tool call of a run step. Only function calls are decoded;
+// the details of other tool types are left in the raw step.
+type RunStepToolCall struct {
+	// Index is only set in the deltas of a stream.
+	Index    *int                 `json:"index,omitempty"`
+	ID       string               `json:"id,omitempty"`
+	Type     string               `json:"type"`
+	Function *RunStepFunctionCall `json:"function,omitempty"`
+}
+
+type RunStepFunctionCall struct {
+	Name      string  `json:"name,omitempty"`
+	Arguments string  `json:"arguments,omitempty"`
+	Output    *string `json:"output,omitempty"`
+}
+
+// CreateRunStream creates a run on a thread and streams its events.
+func (c *Client) CreateRunStream(
+	ctx context.Context,
+	threadID string,
+	request RunRequest,
+	retryOpts ...RetryOptions,
+) (stream *AssistantStream, err error) {
+	request.Stream = true
+	urlSuffix := fmt.Sprintf("/threads/%s/%s", threadID, runsSuffix)
+	call := &Call{
+		Operation:    "CreateRunStream",
+		Method:       http.MethodPost,
+		Path:         urlSuffix,
+		Model:        request.Model,
+		Request:      request,
+		RetryOptions: retryOpts,
+	}
+	return invoke(ctx, c, call, func(ctx context.Context, request RunRequest, call *Call) (*AssistantStream, error) {
+		return c.sendAssistantStreamRequest(ctx, urlSuffix, request, call.RetryOptions)
+	})
+}
+
+// SubmitToolOutputsStream submits the outputs of the tool calls a run requires and
+// streams the events of the continued run.
+func (c *Client) SubmitToolOutputsStream(
+	ctx context.Context,
+	threadID, runID string,
+	request SubmitToolOutputsRequest,
+	retryOpts ...RetryOptions,
+) (stream *AssistantStream, err error) {
+	request.Stream = true
+	urlSuffix := fmt.Sprintf("/threads/%s/%s/%s/submit_tool_outputs", threadID, runsSuffix, runID)
+	call := &Call{
+		Operation:    "SubmitToolOutputsStream",
+		Method:       http.MethodPost,
+		Path:         urlSuffix,
+		Request:      request,
+		RetryOptions: retryOpts,
+	}
+	return invoke(ctx, c, call, func(ctx context.Context, request SubmitToolOutputsRequest, call *Call) (*AssistantStream, error) {
+		return c.sendAssistantStreamRequest(ctx, urlSuffix, request, call.RetryOptions)
+	})
+}
+
+func (c *Client) sendAssistantStreamRequest(
+	ctx context.Context,
+	urlSuffix string,
+	request any,
+	retryOpts []RetryOptions,
+) (stream *AssistantStream, err error) {
+	req, err := c.newRequest(ctx, http.MethodPost, c.fullURL(urlSuffix), withBody(request))
+	if err != nil {
+		return
+	}
+
+	resp, err := sendRequestStream[AssistantStreamEvent](c, req, retryOpts...)
+	if err != nil {
+		return
+	}
+	stream = &AssistantStream{
+		streamReader: resp,
+	}
+	return
+}
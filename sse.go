@@ -0,0 +1,181 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"time"
+)
+
+// sse.go implements a decoder for the server-sent events wire format as specified in
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// sseEvent is a single dispatched server-sent event.
+type sseEvent struct {
+	// Event is the event name. It is empty for unnamed events, which the spec treats as "message".
+	Event string
+	// Data is the concatenation of all data fields with the trailing newline removed.
+	Data []byte
+	// ID is the last event ID at the time the event was dispatched.
+	ID string
+}
+
+type sseDecoder struct {
+	reader *bufio.Reader
+
+	// invalid collects the lines that are not valid fields, e.g. a plain JSON
+	// error body sent in place of an event stream.
+	invalid      *bytes.Buffer
+	invalidLimit uint
+
+	started bool
+	// skipLF is set after a line ended with a CR, in case it is followed by a LF.
+	skipLF      bool
+	lastEventID string
+	retry       time.Duration
+
+	eventType string
+	data      bytes.Buffer
+	hasData   bool
+}
+
+func newSSEDecoder(r io.Reader, invalidLimit uint) *sseDecoder {
+	return &sseDecoder{
+		reader:       bufio.NewReader(r),
+		invalid:      &bytes.Buffer{},
+		invalidLimit: invalidLimit,
+	}
+}
+
+// Next reads lines until an event is dispatched. Events without data are not
+// dispatched, as required by the spec. If the input ends in the middle of an event,
+// the pending event is discarded and io.ErrUnexpectedEOF is returned.
+func (d *sseDecoder) Next() (sseEvent, error) {
+	var invalidCount uint
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			if err == io.EOF && d.hasData {
+				d.eventType = ""
+				d.data.Reset()
+				d.hasData = false
+				return sseEvent{}, io.ErrUnexpectedEOF
+			}
+			return sseEvent{}, err
+		}
+
+		if len(line) == 0 {
+			if d.hasData {
+				return d.dispatch(), nil
+			}
+			d.eventType = ""
+			continue
+		}
+
+		if line[0] == ':' {
+			// comment, commonly used as keep-alive
+			continue
+		}
+
+		field, value, found := bytes.Cut(line, []byte(":"))
+		if found {
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+
+		switch string(field) {
+		case "event":
+			d.eventType = string(value)
+		case "data":
+			d.data.Write(value)
+			d.data.WriteByte('\n')
+			d.hasData = true
+		case "id":
+			if !bytes.ContainsRune(value, 0) {
+				d.lastEventID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		default:
+			if found && isSSEFieldName(field) {
+				// unknown fields are ignored
+				continue
+			}
+			d.invalid.Write(bytes.TrimSpace(line))
+			invalidCount++
+			if invalidCount > d.invalidLimit {
+				return sseEvent{}, ErrTooManyEmptyStreamMessages
+			}
+		}
+	}
+}
+
+func (d *sseDecoder) dispatch() sseEvent {
+	data := bytes.TrimSuffix(d.data.Bytes(), []byte("\n"))
+	event := sseEvent{
+		Event: d.eventType,
+		Data:  bytes.Clone(data),
+		ID:    d.lastEventID,
+	}
+	d.eventType = ""
+	d.data.Reset()
+	d.hasData = false
+	return event
+}
+
+// readLine returns the next line without its terminator. Lines may be terminated by
+// CRLF, LF or a lone CR. A line ends at the CR right away, so that a stream is not held
+// up waiting for the LF that may follow it.
+func (d *sseDecoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return d.trimBOM(line), nil
+			}
+			return nil, err
+		}
+
+		if d.skipLF {
+			d.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\n':
+			return d.trimBOM(line), nil
+		case '\r':
+			d.skipLF = true
+			return d.trimBOM(line), nil
+		}
+		line = append(line, b)
+	}
+}
+
+func (d *sseDecoder) trimBOM(line []byte) []byte {
+	if !d.started {
+		d.started = true
+		line = bytes.TrimPrefix(line, utf8BOM)
+	}
+	return line
+}
+
+// isSSEFieldName reports whether name looks like a field name rather than a line of
+// something that is not an event stream, such as a JSON error body.
+func isSSEFieldName(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
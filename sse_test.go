@@ -0,0 +1,140 @@
+package openai
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEDecoder(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		invalidLimit uint
+		want         []sseEvent
+		wantErr      error
+	}{
+		{
+			name:    "LF",
+			input:   "data: a\n\ndata: b\n\n",
+			want:    []sseEvent{{Data: []byte("a")}, {Data: []byte("b")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "CRLF",
+			input:   "event: message\r\ndata: a\r\n\r\ndata: b\r\n\r\n",
+			want:    []sseEvent{{Event: "message", Data: []byte("a")}, {Data: []byte("b")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "lone CR",
+			input:   "data: a\r\rdata: b\r\r",
+			want:    []sseEvent{{Data: []byte("a")}, {Data: []byte("b")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "multi-line data",
+			input:   "data: {\ndata:  \"a\": 1\ndata: }\n\n",
+			want:    []sseEvent{{Data: []byte("{\n \"a\": 1\n}")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "comments",
+			input:   ": keep-alive\n\n:\ndata: a\n: inside an event\n\n",
+			want:    []sseEvent{{Data: []byte("a")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "leading BOM",
+			input:   "\xEF\xBB\xBFdata: a\n\n",
+			want:    []sseEvent{{Data: []byte("a")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "id and retry",
+			input:   "id: 1\nretry: 1000\ndata: a\n\ndata: b\n\n",
+			want:    []sseEvent{{Data: []byte("a"), ID: "1"}, {Data: []byte("b"), ID: "1"}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "unknown fields",
+			input:   "x-custom: 1\nfoo_bar:\ndata: a\n\n",
+			want:    []sseEvent{{Data: []byte("a")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "event without data",
+			input:   "event: ping\n\ndata: a\n\n",
+			want:    []sseEvent{{Data: []byte("a")}},
+			wantErr: io.EOF,
+		},
+		{
+			name:         "invalid lines within limit",
+			input:        "not an event\ndata: a\n\n",
+			invalidLimit: 1,
+			want:         []sseEvent{{Data: []byte("a")}},
+			wantErr:      io.EOF,
+		},
+		{
+			name:         "invalid lines over limit",
+			input:        "{\n\"error\": {}\n}\n",
+			invalidLimit: 2,
+			wantErr:      ErrTooManyEmptyStreamMessages,
+		},
+		{
+			name:    "unterminated final event",
+			input:   "data: a\n\ndata: b\n",
+			want:    []sseEvent{{Data: []byte("a")}},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "final line without line ending",
+			input:   "data: a\n\ndata: b",
+			want:    []sseEvent{{Data: []byte("a")}},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := newSSEDecoder(strings.NewReader(tt.input), tt.invalidLimit)
+			var got []sseEvent
+			var err error
+			for {
+				var event sseEvent
+				event, err = decoder.Next()
+				if err != nil {
+					break
+				}
+				got = append(got, event)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEDecoderFields(t *testing.T) {
+	decoder := newSSEDecoder(strings.NewReader("retry: 1500\nid: 7\ndata: a\n\n"), 0)
+	if _, err := decoder.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if decoder.retry != 1500*time.Millisecond || decoder.lastEventID != "7" {
+		t.Errorf("got retry %v and last event ID %q, want 1.5s and 7", decoder.retry, decoder.lastEventID)
+	}
+
+	// a plain JSON error body is kept so that it can be reported
+	decoder = newSSEDecoder(strings.NewReader(`{"error": {"message": "boom"}}`+"\n"), 0)
+	if _, err := decoder.Next(); !errors.Is(err, ErrTooManyEmptyStreamMessages) {
+		t.Fatalf("got error %v, want %v", err, ErrTooManyEmptyStreamMessages)
+	}
+	if got := decoder.invalid.String(); got != `{"error": {"message": "boom"}}` {
+		t.Errorf("got invalid lines %q", got)
+	}
+}
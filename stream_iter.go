@@ -0,0 +1,83 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+)
+
+type streamReceiver[T any] interface {
+	Recv() (T, error)
+	Close() error
+}
+
+// StreamResult is a single value received from a stream channel. Exactly one of
+// Value and Err is set.
+type StreamResult[T any] struct {
+	Value T
+	Err   error
+}
+
+// streamAll returns an iterator over the values of a stream. The stream is closed
+// when it is exhausted, when it fails and when the loop is stopped early.
+func streamAll[T any](stream streamReceiver[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		defer stream.Close()
+		for {
+			value, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				yield(value, err)
+				return
+			}
+			if !yield(value, nil) {
+				return
+			}
+		}
+	}
+}
+
+// streamChan pumps the values of a stream into a channel. The channel is closed and
+// the stream is closed when the stream is exhausted, when it fails and when ctx is done.
+func streamChan[T any](ctx context.Context, stream streamReceiver[T]) <-chan StreamResult[T] {
+	results := make(chan StreamResult[T])
+
+	// closing the stream unblocks a pending Recv
+	stop := context.AfterFunc(ctx, func() {
+		_ = stream.Close()
+	})
+
+	go func() {
+		defer close(results)
+		defer stop()
+		defer stream.Close()
+
+		for {
+			value, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+
+			result := StreamResult[T]{Value: value, Err: err}
+			if err != nil {
+				result.Value = *new(T)
+			}
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return results
+}
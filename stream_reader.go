@@ -1,107 +1,149 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
 var (
-	headerData                    = []byte("data: ")
-	errorPrefix                   = []byte(`data: {"error":`)
+	doneData                      = []byte("[DONE]")
+	errorKey                      = []byte(`"error"`)
 	ErrTooManyEmptyStreamMessages = errors.New("stream has sent too many empty messages")
 )
 
 type streamable interface {
-	ChatCompletionStreamResponse
+	ChatCompletionStreamResponse | AssistantStreamEvent
+}
+
+// sseUnmarshaler is implemented by stream payloads that need the event name in
+// addition to the data in order to decode themselves.
+type sseUnmarshaler interface {
+	unmarshalSSE(event string, data []byte) error
 }
 
 type streamReader[T streamable] struct {
-	emptyMessagesLimit uint
-	isFinished         bool
+	isFinished bool
+
+	decoder  *sseDecoder
+	response *http.Response
+	watchdog *streamWatchdog
+	tee      *streamTee
+
+	// sentAt and headersAt are the times the request was sent and the response headers arrived.
+	sentAt    time.Time
+	headersAt time.Time
+	// receivedAt is the time the bytes that completed the last decoded event were read from
+	// the connection. Events that are decoded from bytes read at once share the time.
+	receivedAt time.Time
+	// data is the data of the last decoded event as it was received.
+	data []byte
 
-	reader    *bufio.Reader
-	response  *http.Response
-	errBuffer *bytes.Buffer
+	// peeked holds the first result if it was read ahead by sendRequestStream.
+	peeked *peekedResult[T]
 
 	httpHeader
 }
 
+func newStreamReader[T streamable](resp *http.Response, emptyMessagesLimit uint) *streamReader[T] {
+	// only chat completion streams can be recorded, see ChatCompletionStream.Record
+	_, recordable := any(*new(T)).(ChatCompletionStreamResponse)
+	tee := newStreamTee(resp.Body, recordable)
+	return &streamReader[T]{
+		decoder:    newSSEDecoder(tee, emptyMessagesLimit),
+		response:   resp,
+		tee:        tee,
+		httpHeader: httpHeader{header: resp.Header, apiKeyID: apiKeyIDOf(resp)},
+	}
+}
+
+type peekedResult[T streamable] struct {
+	response T
+	err      error
+}
+
 func (stream *streamReader[T]) Recv() (response T, err error) {
+	if peeked := stream.peeked; peeked != nil {
+		stream.peeked = nil
+		return peeked.response, peeked.err
+	}
+
 	if stream.isFinished {
 		err = io.EOF
 		return
 	}
 
-	response, err = stream.processLines()
+	response, err = stream.processEvents()
 	return
 }
 
-//nolint:gocognit
-func (stream *streamReader[T]) processLines() (T, error) {
-	var (
-		emptyMessagesCount uint
-		hasErrorPrefix     bool
-	)
-
-	for {
-		rawLine, readErr := stream.reader.ReadBytes('\n')
-		if readErr != nil || hasErrorPrefix {
-			respErr := stream.unmarshalError()
-			if respErr != nil {
-				return *new(T), fmt.Errorf("error, %w", respErr.Error)
-			}
-			return *new(T), readErr
-		}
+// peek reads the first result ahead of Recv. It returns the error the stream failed with.
+func (stream *streamReader[T]) peek() error {
+	response, err := stream.Recv()
+	stream.peeked = &peekedResult[T]{response: response, err: err}
+	return err
+}
 
-		noSpaceLine := bytes.TrimSpace(rawLine)
-		if bytes.HasPrefix(noSpaceLine, errorPrefix) {
-			hasErrorPrefix = true
-		}
-		if !bytes.HasPrefix(noSpaceLine, headerData) || hasErrorPrefix {
-			if hasErrorPrefix {
-				noSpaceLine = bytes.TrimPrefix(noSpaceLine, headerData)
-			}
-			_, writeErr := stream.errBuffer.Write(noSpaceLine)
-			if writeErr != nil {
-				return *new(T), writeErr
-			}
-			emptyMessagesCount++
-			if emptyMessagesCount > stream.emptyMessagesLimit {
-				return *new(T), ErrTooManyEmptyStreamMessages
-			}
-
-			continue
-		}
+// LastEventID returns the ID of the last event received, if the server sends any.
+func (stream *streamReader[T]) LastEventID() string {
+	return stream.decoder.lastEventID
+}
 
-		noPrefixLine := bytes.TrimPrefix(noSpaceLine, headerData)
-		if string(noPrefixLine) == "[DONE]" {
-			stream.isFinished = true
-			return *new(T), io.EOF
+func (stream *streamReader[T]) processEvents() (T, error) {
+	stream.watchdog.arm()
+	event, readErr := stream.decoder.Next()
+	if readErr == nil {
+		stream.receivedAt = stream.tee.readAt()
+		stream.data = event.Data
+	}
+	if timeoutErr := stream.watchdog.disarm(readErr == nil); readErr != nil && timeoutErr != nil {
+		return *new(T), timeoutErr
+	}
+	if readErr != nil {
+		respErr := stream.unmarshalError(stream.decoder.invalid.Bytes())
+		if respErr != nil {
+			return *new(T), fmt.Errorf("error, %w", respErr.Error)
 		}
+		return *new(T), readErr
+	}
 
-		var response T
-		unmarshalErr := json.Unmarshal(noPrefixLine, &response)
-		if unmarshalErr != nil {
-			return *new(T), unmarshalErr
+	if bytes.Equal(event.Data, doneData) {
+		stream.isFinished = true
+		return *new(T), io.EOF
+	}
+
+	if event.Event == "error" || bytes.Contains(event.Data, errorKey) {
+		respErr := stream.unmarshalError(event.Data)
+		if respErr != nil {
+			return *new(T), fmt.Errorf("error, %w", respErr.Error)
 		}
+	}
 
+	var response T
+	if u, ok := any(&response).(sseUnmarshaler); ok {
+		if err := u.unmarshalSSE(event.Event, event.Data); err != nil {
+			return *new(T), err
+		}
 		return response, nil
 	}
+
+	if err := json.Unmarshal(event.Data, &response); err != nil {
+		return *new(T), err
+	}
+	return response, nil
 }
 
-func (stream *streamReader[T]) unmarshalError() (errResp *ErrorResponse) {
-	errBytes := stream.errBuffer.Bytes()
-	if len(errBytes) == 0 {
+func (stream *streamReader[T]) unmarshalError(data []byte) (errResp *ErrorResponse) {
+	if len(data) == 0 {
 		return
 	}
 
-	err := json.Unmarshal(errBytes, &errResp)
-	if err != nil {
+	err := json.Unmarshal(data, &errResp)
+	if err != nil || errResp.Error == nil {
 		errResp = nil
 	}
 
@@ -109,5 +151,5 @@ func (stream *streamReader[T]) unmarshalError() (errResp *ErrorResponse) {
 }
 
 func (stream *streamReader[T]) Close() error {
-	return stream.response.Body.Close()
+	return stream.tee.Close()
 }
@@ -0,0 +1,105 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrStreamTimeout = errors.New("stream timed out")
+
+// StreamTimeoutError is returned when a stream does not send a chunk in time, see
+// ClientConfig.StreamFirstChunkTimeout and ClientConfig.StreamChunkIdleTimeout.
+type StreamTimeoutError struct {
+	// FirstChunk is set if the stream timed out before sending its first chunk.
+	FirstChunk bool
+	// Duration is the timeout that was exceeded.
+	Duration time.Duration
+}
+
+func (e *StreamTimeoutError) Error() string {
+	if e.FirstChunk {
+		return fmt.Sprintf("stream timed out waiting %s for the first chunk", e.Duration)
+	}
+	return fmt.Sprintf("stream timed out waiting %s for the next chunk", e.Duration)
+}
+
+// Is reports whether target is ErrStreamTimeout.
+func (e *StreamTimeoutError) Is(target error) bool {
+	return target == ErrStreamTimeout
+}
+
+// Timeout reports true, the same as net.Error does for timeouts.
+func (e *StreamTimeoutError) Timeout() bool {
+	return true
+}
+
+// streamWatchdog closes the response body when a chunk does not arrive in time,
+// which unblocks the pending read.
+type streamWatchdog struct {
+	body       io.Closer
+	firstChunk time.Duration
+	idle       time.Duration
+
+	received bool
+	timer    *time.Timer
+
+	mu    sync.Mutex
+	fired *StreamTimeoutError
+}
+
+func newStreamWatchdog(body io.Closer, firstChunk, idle time.Duration) *streamWatchdog {
+	if firstChunk <= 0 && idle <= 0 {
+		return nil
+	}
+	return &streamWatchdog{
+		body:       body,
+		firstChunk: firstChunk,
+		idle:       idle,
+	}
+}
+
+// arm starts the timer for the next chunk.
+func (w *streamWatchdog) arm() {
+	if w == nil {
+		return
+	}
+
+	timeout := &StreamTimeoutError{FirstChunk: !w.received, Duration: w.idle}
+	if timeout.FirstChunk {
+		timeout.Duration = w.firstChunk
+	}
+	if timeout.Duration <= 0 {
+		return
+	}
+
+	w.timer = time.AfterFunc(timeout.Duration, func() {
+		w.mu.Lock()
+		w.fired = timeout
+		w.mu.Unlock()
+		_ = w.body.Close()
+	})
+}
+
+// disarm stops the timer after a chunk was read or reading failed. It returns the
+// timeout error if the timer fired.
+func (w *streamWatchdog) disarm(received bool) error {
+	if w == nil {
+		return nil
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.received = w.received || received
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fired != nil {
+		return w.fired
+	}
+	return nil
+}